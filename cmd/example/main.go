@@ -1,15 +1,22 @@
 package main
 
-import "github.com/tardisman5197/barnes-hut-sim/pkg/simulation"
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/tardisman5197/barnes-hut-sim/pkg/simulation"
+)
 
 const (
-	// theta is used to determine what granularity
-	// to which to calculate the forces for each Body.
-	// Between 1 and 0, 1 being full granularity.
+	// theta is the opening angle of the Barnes-Hut
+	// approximation, 0 calculates every force directly
+	// and larger values are faster but less accurate.
 	theta = 0.5
-	// grav is the gravitational constant used to calculate
-	// the forces between bodies.
-	grav = 9.81
+	// units are the units the simulation is in, which give
+	// the gravitational constant used to calculate the forces
+	// between bodies. In N-body units it is 1.
+	units = simulation.UnitsNBody
 )
 
 // main this is run when the program is executed.
@@ -21,6 +28,21 @@ func main() {
 		simulation.Body{Name: "stuff", X: 1.0, Y: 10.0, Z: 1.0, Radius: 1, Density: 1},
 	}
 
-	simulation := simulation.NewSimulation(grav, theta, bodies...)
-	simulation.Steps(10)
+	sim := simulation.NewSimulation(0, theta, bodies...)
+	if err := sim.SetUnits(units); err != nil {
+		log.Fatal(err)
+	}
+
+	// Report what the simulation is doing and where the
+	// bodies are after each step
+	sim.Logger = log.New(os.Stdout, "", 0)
+	sim.AddObserver(simulation.Observer{
+		AfterStep: func(s *simulation.Simulation) {
+			for _, b := range s.Bodies {
+				fmt.Printf("\t%s: x=%v y=%v z=%v\n", b.Name, b.X, b.Y, b.Z)
+			}
+		},
+	})
+
+	sim.Steps(10)
 }
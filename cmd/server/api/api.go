@@ -17,6 +17,22 @@ type API struct {
 
 	mutex       *sync.RWMutex
 	simulations map[string]*simulation.Simulation
+	// runs holds the simulations which are running
+	runs map[string]*run
+	// checkpoints saves the simulations to disk, once
+	// enabled with EnableCheckpoints
+	checkpoints *checkpoints
+}
+
+// run is a simulation running in the background.
+type run struct {
+	// cancel stops the run
+	cancel context.CancelFunc
+	// progress is how far the run has got
+	progress simulation.Progress
+	// interrupted is set when the run is stopped by the
+	// server shutting down, so it is resumed on restart
+	interrupted bool
 }
 
 // NewAPI returns an instance of an API struct.
@@ -25,6 +41,8 @@ func NewAPI() API {
 	a.setup()
 	a.mutex = &sync.RWMutex{}
 	a.simulations = make(map[string]*simulation.Simulation)
+	a.runs = make(map[string]*run)
+	a.checkpoints = &checkpoints{}
 	return a
 }
 
@@ -32,9 +50,14 @@ func (a *API) router() *mux.Router {
 	r := mux.NewRouter()
 	r.HandleFunc("/simulation/new", a.newSimulation).Methods("POST")
 	r.HandleFunc("/simulation/start/{simID}/{steps}", a.start).Methods("GET")
+	r.HandleFunc("/simulation/stop/{simID}", a.stop).Methods("GET")
 	r.HandleFunc("/simulation/status/{simID}", a.status).Methods("GET")
+	r.HandleFunc("/simulation/diagnostics/{simID}", a.diagnostics).Methods("GET")
 	r.HandleFunc("/simulation/results/{simID}", a.results).Methods("GET")
 	r.HandleFunc("/simulation/remove/{simID}", a.remove).Methods("GET")
+	r.HandleFunc("/simulation/accuracy/{simID}", a.accuracy).Methods("GET")
+	r.HandleFunc("/simulation/tree/{simID}", a.tree).Methods("GET")
+	r.HandleFunc("/simulation/elements/{simID}", a.elements).Methods("GET")
 	return r
 }
 
@@ -65,7 +88,32 @@ func (a *API) Listen() chan bool {
 	return done
 }
 
-// Shutdown gracefully stops the api server.
+// Shutdown gracefully stops the api server. Running
+// simulations are stopped and, when checkpoints are enabled,
+// saved before it returns.
 func (a *API) Shutdown(ctx context.Context) error {
-	return a.server.Shutdown(ctx)
+	err := a.server.Shutdown(ctx)
+
+	a.mutex.Lock()
+	for _, r := range a.runs {
+		r.interrupted = true
+		r.cancel()
+	}
+	a.mutex.Unlock()
+
+	// Wait for the runs to finish their step and save
+	done := make(chan struct{})
+	go func() {
+		a.checkpoints.running.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		if err == nil {
+			err = ctx.Err()
+		}
+	}
+	return err
 }
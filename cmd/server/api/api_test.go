@@ -2,14 +2,20 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"github.com/gorilla/mux"
+	"github.com/tardisman5197/barnes-hut-sim/pkg/generate"
 	"github.com/tardisman5197/barnes-hut-sim/pkg/simulation"
 	"io/ioutil"
+	"math"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestEndpointCreateSimulation(t *testing.T) {
@@ -264,3 +270,946 @@ func TestSimulationStatusEndpointWithoutSimID(t *testing.T) {
 		t.Fatalf("unexpected status code %d != %d", rr.Result().StatusCode, http.StatusBadRequest)
 	}
 }
+
+func TestAccuracyApi(t *testing.T) {
+	api := NewAPI()
+	srv := httptest.NewServer(api.router())
+	defer srv.Close()
+
+	api.simulations["test_id"] = simulation.NewSimulation(1, 0.5,
+		simulation.Body{Name: "a", X: 0, Y: 0, Z: 0, Radius: 1, Density: 1},
+		simulation.Body{Name: "b", X: 10, Y: 1, Z: 0, Radius: 1, Density: 1},
+		simulation.Body{Name: "c", X: 11, Y: 0, Z: 1, Radius: 1, Density: 1},
+	)
+
+	resp, err := http.Get(srv.URL + "/simulation/accuracy/test_id?theta=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status code %d != %d", resp.StatusCode, http.StatusOK)
+	}
+
+	var report simulation.AccuracyReport
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Theta != 0 {
+		t.Fatalf("unexpected report theta %f != 0", report.Theta)
+	}
+
+	if len(report.Errors) != 3 {
+		t.Fatalf("expected 3 errors, got %d", len(report.Errors))
+	}
+
+	if report.Max > 1e-9 {
+		t.Fatalf("expected no error with theta 0, got %g", report.Max)
+	}
+}
+
+func TestAccuracyApiWithInvalidTheta(t *testing.T) {
+	api := NewAPI()
+	srv := httptest.NewServer(api.router())
+	defer srv.Close()
+
+	api.simulations["test_id"] = simulation.NewSimulation(1, 0.5)
+
+	resp, err := http.Get(srv.URL + "/simulation/accuracy/test_id?theta=t")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("unexpected status code %d != %d", resp.StatusCode, http.StatusBadRequest)
+	}
+}
+
+func TestDiagnosticsApi(t *testing.T) {
+	api := NewAPI()
+	srv := httptest.NewServer(api.router())
+	defer srv.Close()
+
+	sim := simulation.NewSimulation(1, 0.5,
+		simulation.Body{Name: "a", X: 0, Y: 0, Z: 0, Radius: 1, Density: 1},
+		simulation.Body{Name: "b", X: 10, Y: 0, Z: 0, VY: 1, Radius: 1, Density: 1},
+	)
+	sim.Steps(3)
+	api.simulations["test_id"] = sim
+
+	resp, err := http.Get(srv.URL + "/simulation/diagnostics/test_id")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status code %d != %d", resp.StatusCode, http.StatusOK)
+	}
+
+	var response DiagnosticsSimulationResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+
+	if response.ID != "test_id" {
+		t.Fatalf("unexpected simulation id %s", response.ID)
+	}
+
+	if len(response.Diagnostics) != 4 {
+		t.Fatalf("expected 4 diagnostics, got %d", len(response.Diagnostics))
+	}
+
+	if response.Diagnostics[3].Step != 3 || response.Diagnostics[3].Potential >= 0 {
+		t.Fatalf("unexpected diagnostics %v", response.Diagnostics[3])
+	}
+}
+
+func TestDiagnosticsApiWithInvalidSimID(t *testing.T) {
+	api := NewAPI()
+	srv := httptest.NewServer(api.router())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/simulation/diagnostics/invalid_test_id")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("unexpected status code %d != %d", resp.StatusCode, http.StatusNotFound)
+	}
+}
+
+func TestTreeApi(t *testing.T) {
+	api := NewAPI()
+	srv := httptest.NewServer(api.router())
+	defer srv.Close()
+
+	api.simulations["test_id"] = simulation.NewSimulation(1, 0.5,
+		simulation.Body{Name: "a", X: 0, Y: 0, Z: 0, Radius: 1, Density: 1},
+		simulation.Body{Name: "b", X: 10, Y: 1, Z: 0, Radius: 1, Density: 1},
+		simulation.Body{Name: "c", X: 11, Y: 0, Z: 1, Radius: 1, Density: 1},
+	)
+
+	resp, err := http.Get(srv.URL + "/simulation/tree/test_id")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status code %d != %d", resp.StatusCode, http.StatusOK)
+	}
+
+	var tree TreeSimulationResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tree); err != nil {
+		t.Fatal(err)
+	}
+
+	if tree.ID != "test_id" || len(tree.Nodes) != tree.Stats.Nodes {
+		t.Fatalf("unexpected tree %+v", tree)
+	}
+
+	if tree.Nodes[0].Bodies != 3 {
+		t.Fatalf("expected 3 bodies in the root, got %d", tree.Nodes[0].Bodies)
+	}
+
+	binaryResp, err := http.Get(srv.URL + "/simulation/tree/test_id?format=binary")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer binaryResp.Body.Close()
+
+	data, err := ioutil.ReadAll(binaryResp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nodes, err := simulation.ReadTreeNodes(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(nodes) != len(tree.Nodes) {
+		t.Fatalf("expected %d nodes in the binary tree, got %d", len(tree.Nodes), len(nodes))
+	}
+}
+
+func TestTreeApiWithInvalidFormat(t *testing.T) {
+	api := NewAPI()
+	srv := httptest.NewServer(api.router())
+	defer srv.Close()
+
+	api.simulations["test_id"] = simulation.NewSimulation(1, 0.5)
+
+	resp, err := http.Get(srv.URL + "/simulation/tree/test_id?format=xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("unexpected status code %d != %d", resp.StatusCode, http.StatusBadRequest)
+	}
+}
+
+func TestStopApi(t *testing.T) {
+	api := NewAPI()
+	srv := httptest.NewServer(api.router())
+	defer srv.Close()
+
+	api.simulations["test_id"] = simulation.NewSimulation(1, 0.5,
+		simulation.Body{Name: "a", X: 0, Y: 0, Z: 0, Radius: 1, Density: 1},
+		simulation.Body{Name: "b", X: 10, Y: 1, Z: 0, Radius: 1, Density: 1},
+	)
+
+	get := func(path string) *http.Response {
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	if resp := get("/simulation/stop/test_id"); resp.StatusCode != http.StatusConflict {
+		t.Fatalf("expected a conflict stopping an idle simulation, got %d", resp.StatusCode)
+	}
+
+	// Start a run far too long to finish during the test
+	if resp := get("/simulation/start/test_id/100000000"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status code %d != %d", resp.StatusCode, http.StatusOK)
+	}
+	if resp := get("/simulation/start/test_id/10"); resp.StatusCode != http.StatusConflict {
+		t.Fatalf("expected a conflict starting a running simulation, got %d", resp.StatusCode)
+	}
+
+	resp, err := http.Get(srv.URL + "/simulation/status/test_id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var status StatusSimulationResponse
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if !status.Running || status.Progress == nil {
+		t.Fatalf("expected the simulation to be running, got %+v", status)
+	}
+
+	if resp := get("/simulation/stop/test_id"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status code %d != %d", resp.StatusCode, http.StatusOK)
+	}
+
+	// Wait for the run to finish its last step
+	for i := 0; ; i++ {
+		api.mutex.RLock()
+		_, running := api.runs["test_id"]
+		step := api.simulations["test_id"].Step
+		api.mutex.RUnlock()
+
+		if !running {
+			if step >= 100000000 {
+				t.Fatalf("expected the simulation to stop early, got to step %d", step)
+			}
+			break
+		}
+		if i > 1000 {
+			t.Fatal("the simulation did not stop")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestCheckpointApi(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoints")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	api := NewAPI()
+	if err := api.EnableCheckpoints(dir, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(api.router())
+	defer srv.Close()
+
+	body, err := json.Marshal(NewSimulationRequest{
+		Grav:  1,
+		Theta: 0.5,
+		Bodies: []simulation.Body{
+			{Name: "a", X: 0, Y: 0, Z: 0, Radius: 0.1, Density: 1},
+			{Name: "b", X: 5, Y: 0, Z: 0, Radius: 0.1, Density: 1},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.Post(srv.URL+"/simulation/new", "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var created NewSimulationResponse
+	json.NewDecoder(resp.Body).Decode(&created)
+	resp.Body.Close()
+
+	resp, err = http.Get(srv.URL + "/simulation/start/" + created.ID + "/5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	api.checkpoints.running.Wait()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	api.Shutdown(ctx)
+
+	restarted := NewAPI()
+	if err := restarted.EnableCheckpoints(dir, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	sim, ok := restarted.simulations[created.ID]
+	if !ok {
+		t.Fatalf("expected simulation %s to be restored", created.ID)
+	}
+
+	api.mutex.RLock()
+	original := api.simulations[created.ID]
+	api.mutex.RUnlock()
+	if sim.Step != original.Step || !reflect.DeepEqual(sim.Bodies, original.Bodies) {
+		t.Fatalf("expected the restored simulation at step %d, got step %d", original.Step, sim.Step)
+	}
+
+	resp, err = http.Get(srv.URL + "/simulation/remove/" + created.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if _, err := os.Stat(filepath.Join(dir, created.ID+CheckpointExtension)); !os.IsNotExist(err) {
+		t.Fatalf("expected the checkpoint to be removed, got %v", err)
+	}
+}
+
+func TestResumeCheckpointApi(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoints")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	api := NewAPI()
+	if err := api.EnableCheckpoints(dir, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(api.router())
+	defer srv.Close()
+
+	api.simulations["test_id"] = simulation.NewSimulation(1, 0.5,
+		simulation.Body{Name: "a", X: 0, Y: 0, Z: 0, Radius: 0.1, Density: 1},
+		simulation.Body{Name: "b", X: 5, Y: 1, Z: 0, Radius: 0.1, Density: 1},
+	)
+
+	// Start a run far too long to finish during the test
+	resp, err := http.Get(srv.URL + "/simulation/start/test_id/100000000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	// Shutting down waits for the run to be saved
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	api.Shutdown(ctx)
+
+	if _, err := os.Stat(filepath.Join(dir, "test_id"+RunExtension)); err != nil {
+		t.Fatalf("expected the interrupted run to be recorded, got %v", err)
+	}
+
+	// Restarting resumes the run where it was saved
+	restarted := NewAPI()
+	if err := restarted.EnableCheckpoints(dir, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted.mutex.Lock()
+	r, running := restarted.runs["test_id"]
+	if running {
+		r.cancel()
+	}
+	restarted.mutex.Unlock()
+	if !running {
+		t.Fatal("expected the interrupted run to be resumed")
+	}
+	restarted.checkpoints.running.Wait()
+
+	// Stopping it rather than shutting down ends the run
+	if _, err := os.Stat(filepath.Join(dir, "test_id"+RunExtension)); !os.IsNotExist(err) {
+		t.Fatalf("expected the stopped run to be forgotten, got %v", err)
+	}
+}
+
+func TestCorruptCheckpointApi(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoints")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sim := simulation.NewSimulation(1, 0.5,
+		simulation.Body{Name: "a", X: 0, Y: 0, Z: 0, Radius: 0.1, Density: 1},
+		simulation.Body{Name: "b", X: 5, Y: 0, Z: 0, Radius: 0.1, Density: 1},
+	)
+	if err := sim.SaveCheckpoint(filepath.Join(dir, "GOOD"+CheckpointExtension)); err != nil {
+		t.Fatal(err)
+	}
+	bad := filepath.Join(dir, "BAD"+CheckpointExtension)
+	if err := ioutil.WriteFile(bad, []byte("torn"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The bad checkpoint is moved aside and the rest loaded
+	api := NewAPI()
+	if err := api.EnableCheckpoints(dir, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := api.simulations["GOOD"]; !ok || len(api.simulations) != 1 {
+		t.Fatalf("expected only the good simulation to be loaded, got %d", len(api.simulations))
+	}
+	if _, err := os.Stat(bad + CorruptExtension); err != nil {
+		t.Fatalf("expected the bad checkpoint to be moved aside, got %v", err)
+	}
+}
+
+func TestGeneratorApi(t *testing.T) {
+	api := NewAPI()
+	srv := httptest.NewServer(api.router())
+	defer srv.Close()
+
+	body, err := json.Marshal(NewSimulationRequest{
+		Grav:  1,
+		Theta: 0.5,
+		Generator: &generate.Spec{
+			Model:       generate.ModelPlummer,
+			N:           50,
+			Mass:        1,
+			ScaleRadius: 1,
+			Seed:        3,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.Post(srv.URL+"/simulation/new", "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status code %d != %d", resp.StatusCode, http.StatusOK)
+	}
+
+	var created NewSimulationResponse
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(created.Simulation.Bodies) != 50 {
+		t.Fatalf("expected 50 generated bodies, got %d", len(created.Simulation.Bodies))
+	}
+}
+
+func TestGeneratorApiWithBodies(t *testing.T) {
+	api := NewAPI()
+	srv := httptest.NewServer(api.router())
+	defer srv.Close()
+
+	body, err := json.Marshal(NewSimulationRequest{
+		Grav:      1,
+		Theta:     0.5,
+		Bodies:    []simulation.Body{{Name: "a", Radius: 1, Density: 1}},
+		Generator: &generate.Spec{Model: generate.ModelPlummer, N: 10, Mass: 1, ScaleRadius: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.Post(srv.URL+"/simulation/new", "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("unexpected status code %d != %d", resp.StatusCode, http.StatusBadRequest)
+	}
+}
+
+func TestScenarioApi(t *testing.T) {
+	api := NewAPI()
+	srv := httptest.NewServer(api.router())
+	defer srv.Close()
+
+	spec := &generate.Spec{Model: generate.ModelPlummer, N: 20, Mass: 1, ScaleRadius: 1}
+	body, err := json.Marshal(NewSimulationRequest{
+		Grav:  1,
+		Theta: 0.5,
+		Scenario: &generate.Scenario{Systems: []generate.System{
+			{Spec: spec, Name: "a"},
+			{
+				Spec:  spec,
+				Name:  "b",
+				Orbit: &generate.Orbit{Type: generate.OrbitKepler, Separation: 10, Pericentre: 1, Eccentricity: 1},
+			},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.Post(srv.URL+"/simulation/new", "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status code %d != %d", resp.StatusCode, http.StatusOK)
+	}
+
+	var created NewSimulationResponse
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(created.Simulation.Bodies) != 40 || created.Simulation.Bodies[20].Name != "b-plummer-0" {
+		t.Fatalf("unexpected bodies %+v", created.Simulation.Bodies)
+	}
+}
+
+func TestElementsApi(t *testing.T) {
+	api := NewAPI()
+	srv := httptest.NewServer(api.router())
+	defer srv.Close()
+
+	api.simulations["test_id"] = simulation.NewSimulation(generate.SolarGrav, 0, generate.SolarSystem(generate.SolarGrav)...)
+
+	resp, err := http.Get(srv.URL + "/simulation/elements/test_id")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status code %d != %d", resp.StatusCode, http.StatusOK)
+	}
+
+	var elements ElementsSimulationResponse
+	if err := json.NewDecoder(resp.Body).Decode(&elements); err != nil {
+		t.Fatal(err)
+	}
+
+	if elements.Central != "Sun" || len(elements.Bodies) != 8 || elements.Bodies[2].Name != "Earth" {
+		t.Fatalf("unexpected elements %+v", elements)
+	}
+
+	if a := elements.Bodies[2].Elements.SemiMajorAxis; a < 0.99 || a > 1.01 {
+		t.Fatalf("expected the Earth 1 AU from the Sun, got %v", a)
+	}
+
+	missing, err := http.Get(srv.URL + "/simulation/elements/test_id?central=Pluto")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer missing.Body.Close()
+
+	if missing.StatusCode != http.StatusBadRequest {
+		t.Fatalf("unexpected status code %d != %d", missing.StatusCode, http.StatusBadRequest)
+	}
+}
+
+func TestUnitsApi(t *testing.T) {
+	api := NewAPI()
+	srv := httptest.NewServer(api.router())
+	defer srv.Close()
+
+	// The Earth orbiting the Sun given in SI units and run in
+	// astronomical units
+	body, err := json.Marshal(NewSimulationRequest{
+		Units:      simulation.UnitsAstro,
+		InputUnits: simulation.UnitsSI,
+		Theta:      0.5,
+		Dt:         86400,
+		Bodies: []simulation.Body{
+			{Name: "Sun", Radius: 6.957e8, Density: 1408},
+			{Name: "Earth", X: simulation.AstronomicalUnit, VY: 29.78e3, Radius: 6.371e6, Density: 5514},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.Post(srv.URL+"/simulation/new", "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status code %d != %d", resp.StatusCode, http.StatusOK)
+	}
+
+	var created NewSimulationResponse
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		t.Fatal(err)
+	}
+
+	sim := created.Simulation
+	if sim.Units != simulation.UnitsAstro || math.Abs(sim.Grav-39.4769) > 1e-3 {
+		t.Fatalf("expected astronomical units, got %s with a G of %v", sim.Units, sim.Grav)
+	}
+	if math.Abs(sim.Bodies[1].X-1) > 1e-12 || math.Abs(sim.Dt-1/365.25) > 1e-12 {
+		t.Fatalf("unexpected converted values %+v", sim.Bodies[1])
+	}
+
+	// The results can be read back in SI units
+	results, err := http.Get(srv.URL + "/simulation/results/" + created.ID + "?units=si")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer results.Body.Close()
+
+	var result simulationResultResponse
+	if err := json.NewDecoder(results.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+
+	if vy := result.Simulation.Bodies[1].VY; math.Abs(vy-29.78e3) > 1e-6 {
+		t.Fatalf("expected the Earth's velocity back in SI units, got %v", vy)
+	}
+
+	// A gravitational constant which does not match the units
+	// is refused
+	body, err = json.Marshal(NewSimulationRequest{
+		Grav:  9.81,
+		Units: simulation.UnitsSI,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mismatch, err := http.Post(srv.URL+"/simulation/new", "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer mismatch.Body.Close()
+
+	if mismatch.StatusCode != http.StatusBadRequest {
+		t.Fatalf("unexpected status code %d != %d", mismatch.StatusCode, http.StatusBadRequest)
+	}
+}
+
+func TestPanickingRunApi(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoints")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The run is checkpointed after every step
+	api := NewAPI()
+	if err := api.EnableCheckpoints(dir, time.Nanosecond); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(api.router())
+	defer srv.Close()
+
+	sim := simulation.NewSimulation(1, 0.5,
+		simulation.Body{Name: "a", X: 0, Y: 0, Z: 0, Radius: 1, Density: 1},
+		simulation.Body{Name: "b", X: 10, Y: 1, Z: 0, Radius: 1, Density: 1},
+	)
+	sim.AddObserver(simulation.Observer{
+		AfterStep: func(s *simulation.Simulation) {
+			if s.Step == 3 {
+				panic("broken step")
+			}
+		},
+	})
+	api.simulations["test_id"] = sim
+
+	resp, err := http.Get(srv.URL + "/simulation/start/test_id/10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status code %d != %d", resp.StatusCode, http.StatusOK)
+	}
+	api.checkpoints.running.Wait()
+
+	// The run is dropped, leaving the simulation as it was
+	// and the server still up
+	api.mutex.RLock()
+	_, running := api.runs["test_id"]
+	step := api.simulations["test_id"].Step
+	api.mutex.RUnlock()
+	if running || step != 0 {
+		t.Fatalf("expected the run to be dropped, got running %v at step %d", running, step)
+	}
+
+	// The steps checkpointed before the panic are replaced
+	// by the original
+	saved, err := simulation.LoadCheckpoint(filepath.Join(dir, "test_id"+CheckpointExtension))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Step != 0 {
+		t.Fatalf("expected the original to be checkpointed, got step %d", saved.Step)
+	}
+
+	status, err := http.Get(srv.URL + "/simulation/status/test_id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	status.Body.Close()
+	if status.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status code %d != %d", status.StatusCode, http.StatusOK)
+	}
+}
+
+func TestSolarUnitsApi(t *testing.T) {
+	api := NewAPI()
+	srv := httptest.NewServer(api.router())
+	defer srv.Close()
+
+	post := func(req NewSimulationRequest) *http.Response {
+		body, err := json.Marshal(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.Post(srv.URL+"/simulation/new", "application/json", bytes.NewBuffer(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	// The solar system is in astronomical units, so it cannot
+	// be read as SI
+	mixed := post(NewSimulationRequest{
+		Units:     simulation.UnitsSI,
+		Theta:     0.5,
+		Generator: &generate.Spec{Model: generate.ModelSolar},
+	})
+	mixed.Body.Close()
+	if mixed.StatusCode != http.StatusBadRequest {
+		t.Fatalf("unexpected status code %d != %d", mixed.StatusCode, http.StatusBadRequest)
+	}
+
+	// But it can be converted to SI
+	resp := post(NewSimulationRequest{
+		Units:      simulation.UnitsSI,
+		InputUnits: simulation.UnitsAstro,
+		Theta:      0.5,
+		Generator:  &generate.Spec{Model: generate.ModelSolar},
+	})
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status code %d != %d", resp.StatusCode, http.StatusOK)
+	}
+
+	var created NewSimulationResponse
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		t.Fatal(err)
+	}
+
+	sun, earth := created.Simulation.Bodies[0], created.Simulation.Bodies[3]
+	distance := math.Sqrt(math.Pow(earth.X-sun.X, 2) + math.Pow(earth.Y-sun.Y, 2) + math.Pow(earth.Z-sun.Z, 2))
+	if earth.Name != "Earth" || math.Abs(distance/simulation.AstronomicalUnit-1) > 0.02 {
+		t.Fatalf("expected the Earth 1 AU from the Sun in metres, got %s at %v", earth.Name, distance)
+	}
+}
+
+func TestUnitsEndpointsApi(t *testing.T) {
+	api := NewAPI()
+	srv := httptest.NewServer(api.router())
+	defer srv.Close()
+
+	sim := simulation.NewSimulation(0, 0.5)
+	if err := sim.SetUnits(simulation.UnitsAstro); err != nil {
+		t.Fatal(err)
+	}
+	sim.Bodies = generate.SolarSystem(sim.Grav)
+	sim.Time = 2
+	api.simulations["test_id"] = sim
+
+	get := func(path string, response interface{}) int {
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(resp.Body).Decode(response); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return resp.StatusCode
+	}
+
+	var status StatusSimulationResponse
+	if code := get("/simulation/status/test_id?units=si", &status); code != http.StatusOK || math.Abs(status.Time-2*simulation.JulianYear) > 1e-3 {
+		t.Fatalf("expected a time of two years in seconds, got %d %v", code, status.Time)
+	}
+
+	var elements ElementsSimulationResponse
+	if code := get("/simulation/elements/test_id?units=si", &elements); code != http.StatusOK {
+		t.Fatalf("unexpected status code %d != %d", code, http.StatusOK)
+	}
+	if a := elements.Bodies[2].Elements.SemiMajorAxis / simulation.AstronomicalUnit; a < 0.99 || a > 1.01 {
+		t.Fatalf("expected the Earth 1 AU from the Sun in metres, got %v AU", a)
+	}
+
+	var tree TreeSimulationResponse
+	if code := get("/simulation/tree/test_id?units=si", &tree); code != http.StatusOK {
+		t.Fatalf("unexpected status code %d != %d", code, http.StatusOK)
+	}
+	if m := tree.Nodes[0].Mass / simulation.SolarMass; m < 1 || m > 1.01 {
+		t.Fatalf("expected the tree to hold about a solar mass in kilograms, got %v solar masses", m)
+	}
+
+	var report simulation.AccuracyReport
+	if code := get("/simulation/accuracy/test_id?units=si", &report); code != http.StatusOK {
+		t.Fatalf("unexpected status code %d != %d", code, http.StatusOK)
+	}
+
+	// N-body units have no size to convert to
+	for _, endpoint := range []string{"status", "elements", "tree", "accuracy", "results", "diagnostics"} {
+		var ignored interface{}
+		if code := get("/simulation/"+endpoint+"/test_id?units=nbody", &ignored); code != http.StatusBadRequest {
+			t.Fatalf("%s: unexpected status code %d != %d", endpoint, code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestRunningStatusApi(t *testing.T) {
+	api := NewAPI()
+	srv := httptest.NewServer(api.router())
+	defer srv.Close()
+
+	api.simulations["test_id"] = simulation.NewSimulation(1, 0.5,
+		simulation.Body{Name: "a", X: 0, Y: 0, Z: 0, Radius: 1, Density: 1},
+		simulation.Body{Name: "b", X: 10, Y: 1, Z: 0, Radius: 1, Density: 1},
+	)
+
+	// Start a run far too long to finish during the test
+	resp, err := http.Get(srv.URL + "/simulation/start/test_id/100000000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	defer func() {
+		api.mutex.Lock()
+		api.runs["test_id"].cancel()
+		api.mutex.Unlock()
+		api.checkpoints.running.Wait()
+	}()
+
+	// The status follows the run as it takes steps
+	for i := 0; ; i++ {
+		resp, err := http.Get(srv.URL + "/simulation/status/test_id")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var status StatusSimulationResponse
+		if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if status.Running && status.Progress.Done > 0 {
+			if status.Step != status.Progress.Step || status.Step == 0 || status.Time <= 0 {
+				t.Fatalf("expected the status of the running simulation, got %+v", status)
+			}
+			break
+		}
+		if i > 1000 {
+			t.Fatal("the simulation did not take a step")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestMaxBodiesApi(t *testing.T) {
+	api := NewAPI()
+	srv := httptest.NewServer(api.router())
+	defer srv.Close()
+
+	post := func(req NewSimulationRequest) int {
+		body, err := json.Marshal(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.Post(srv.URL+"/simulation/new", "application/json", bytes.NewBuffer(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// The bodies are counted before any are generated, so
+	// these are turned away straight away
+	spec := generate.Spec{Model: generate.ModelPlummer, N: MaxBodies + 1, Mass: 1, ScaleRadius: 1}
+	if code := post(NewSimulationRequest{Grav: 1, Theta: 0.5, Generator: &spec}); code != http.StatusBadRequest {
+		t.Fatalf("unexpected status code %d != %d", code, http.StatusBadRequest)
+	}
+
+	galaxy := generate.Spec{
+		Model: generate.ModelGalaxy, N: MaxBodies / 2, Mass: 1, ScaleRadius: 1,
+		Galaxy: &generate.Galaxy{Halo: &generate.Halo{Component: generate.Component{N: MaxBodies/2 + 1, Mass: 1, ScaleRadius: 1}}},
+	}
+	if code := post(NewSimulationRequest{Grav: 1, Theta: 0.5, Generator: &galaxy}); code != http.StatusBadRequest {
+		t.Fatalf("unexpected status code %d != %d", code, http.StatusBadRequest)
+	}
+
+	half := spec
+	half.N = MaxBodies/2 + 1
+	scenario := generate.Scenario{Systems: []generate.System{
+		{Spec: &half},
+		{Spec: &half, Orbit: &generate.Orbit{Type: generate.OrbitImpact, Separation: 10, Velocity: 1}},
+	}}
+	if code := post(NewSimulationRequest{Grav: 1, Theta: 0.5, Scenario: &scenario}); code != http.StatusBadRequest {
+		t.Fatalf("unexpected status code %d != %d", code, http.StatusBadRequest)
+	}
+
+	spec.N = 100
+	if code := post(NewSimulationRequest{Grav: 1, Theta: 0.5, Generator: &spec}); code != http.StatusOK {
+		t.Fatalf("unexpected status code %d != %d", code, http.StatusOK)
+	}
+	if len(api.simulations) != 1 {
+		t.Fatalf("expected 1 simulation to be stored, got %d", len(api.simulations))
+	}
+}
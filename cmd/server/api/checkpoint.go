@@ -0,0 +1,185 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tardisman5197/barnes-hut-sim/pkg/simulation"
+)
+
+// checkpoints saves the simulations to a directory so they
+// survive the server restarting.
+type checkpoints struct {
+	// dir is where the checkpoints are saved, nothing is
+	// saved when it is empty
+	dir string
+	// interval is how often a running simulation is saved
+	interval time.Duration
+	// running counts the runs which have not finished
+	running sync.WaitGroup
+}
+
+// EnableCheckpoints saves every simulation to a checkpoint in
+// dir when it is created, every interval while it runs and when
+// a run finishes or is stopped. The simulations already
+// checkpointed in dir are loaded, carrying on from the step
+// they were last saved at. Checkpoints which cannot be loaded
+// are logged and renamed to end in CorruptExtension, so they
+// are kept without stopping the rest from being loaded. Runs
+// interrupted by the server shutting down are started again
+// for the steps they had left.
+func (a *API) EnableCheckpoints(dir string, interval time.Duration) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	if interval <= 0 {
+		interval = DefaultCheckpointInterval
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.checkpoints.dir = dir
+	a.checkpoints.interval = interval
+
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || !strings.HasSuffix(name, CheckpointExtension) {
+			continue
+		}
+
+		path := filepath.Join(dir, name)
+		sim, err := simulation.LoadCheckpoint(path)
+		if err != nil {
+			log.Printf("skipping checkpoint: %v", err)
+			if err := os.Rename(path, path+CorruptExtension); err != nil {
+				log.Printf("moving aside checkpoint: %v", err)
+			}
+			continue
+		}
+		a.simulations[strings.TrimSuffix(name, CheckpointExtension)] = sim
+	}
+
+	// Resume the runs which were interrupted
+	for simID, sim := range a.simulations {
+		target, ok := a.loadRun(simID)
+		if !ok {
+			continue
+		}
+		if target <= sim.Step {
+			a.removeRun(simID)
+			continue
+		}
+
+		log.Printf("resuming simulation %s at step %d of %d", simID, sim.Step, target)
+		a.run(simID, target-sim.Step)
+	}
+	return nil
+}
+
+// checkpoint saves the simulation with the ID simID, when
+// checkpoints are enabled. Failures are logged, as they should
+// not stop the simulation.
+func (a *API) checkpoint(simID string, sim *simulation.Simulation) {
+	if a.checkpoints.dir == "" {
+		return
+	}
+
+	if err := sim.SaveCheckpoint(a.checkpointPath(simID)); err != nil {
+		log.Printf("checkpointing simulation %s: %v", simID, err)
+	}
+}
+
+// removeCheckpoint deletes the checkpoint of the simulation
+// with the ID simID, along with any run it was part of.
+func (a *API) removeCheckpoint(simID string) {
+	if a.checkpoints.dir == "" {
+		return
+	}
+
+	err := os.Remove(a.checkpointPath(simID))
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("removing the checkpoint of simulation %s: %v", simID, err)
+	}
+	a.removeRun(simID)
+}
+
+// runState is saved while a simulation runs so the run can be
+// resumed if the server restarts before it finishes.
+type runState struct {
+	// Target is the step the run finishes at.
+	Target int `json:"target"`
+}
+
+// saveRun records that the simulation with the ID simID is
+// running to the target step, when checkpoints are enabled.
+func (a *API) saveRun(simID string, target int) {
+	if a.checkpoints.dir == "" {
+		return
+	}
+
+	data, err := json.Marshal(runState{Target: target})
+	if err == nil {
+		err = ioutil.WriteFile(a.runPath(simID), data, 0644)
+	}
+	if err != nil {
+		log.Printf("saving the run of simulation %s: %v", simID, err)
+	}
+}
+
+// loadRun returns the step the simulation with the ID simID
+// was running to, if it was interrupted.
+func (a *API) loadRun(simID string) (int, bool) {
+	data, err := ioutil.ReadFile(a.runPath(simID))
+	if os.IsNotExist(err) {
+		return 0, false
+	}
+
+	var state runState
+	if err == nil {
+		err = json.Unmarshal(data, &state)
+	}
+	if err != nil {
+		log.Printf("loading the run of simulation %s: %v", simID, err)
+		return 0, false
+	}
+	return state.Target, true
+}
+
+// removeRun deletes the record of the run of the simulation
+// with the ID simID.
+func (a *API) removeRun(simID string) {
+	if a.checkpoints.dir == "" {
+		return
+	}
+
+	err := os.Remove(a.runPath(simID))
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("removing the run of simulation %s: %v", simID, err)
+	}
+}
+
+// checkpointPath returns the path of the checkpoint of the
+// simulation with the ID simID.
+func (a *API) checkpointPath(simID string) string {
+	return filepath.Join(a.checkpoints.dir, fmt.Sprintf("%s%s", simID, CheckpointExtension))
+}
+
+// runPath returns the path of the record of the run of the
+// simulation with the ID simID.
+func (a *API) runPath(simID string) string {
+	return filepath.Join(a.checkpoints.dir, fmt.Sprintf("%s%s", simID, RunExtension))
+}
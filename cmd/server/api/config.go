@@ -1,7 +1,30 @@
 package api
 
+import "time"
+
 const (
 	// SimulationIDLength determins the length of the
 	// simulation ID string
 	SimulationIDLength = 5
+
+	// MaxBodies is the most bodies a new simulation can have,
+	// whether they are listed, generated or from a scenario
+	MaxBodies = 1000000
+
+	// CheckpointExtension ends the name of each checkpoint
+	// file, which is the simulation ID followed by it
+	CheckpointExtension = ".ckpt"
+
+	// RunExtension ends the name of the file recording the
+	// step a running simulation is being run to, which is
+	// kept while it runs so the run can be resumed
+	RunExtension = ".run"
+
+	// CorruptExtension is added to the name of a checkpoint
+	// file which could not be loaded
+	CorruptExtension = ".corrupt"
+
+	// DefaultCheckpointInterval is how often a running
+	// simulation is checkpointed by default
+	DefaultCheckpointInterval = time.Minute
 )
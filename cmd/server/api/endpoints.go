@@ -1,21 +1,56 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
+	"runtime/debug"
 	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
 
+	"github.com/tardisman5197/barnes-hut-sim/pkg/generate"
 	"github.com/tardisman5197/barnes-hut-sim/pkg/simulation"
 )
 
 type NewSimulationRequest struct {
-	Grav   float64           `json:"grav"`
-	Theta  float64           `json:"theta"`
-	Bodies []simulation.Body `json:"bodies,omitempty"`
+	// Grav is the gravitational constant, which can be left
+	// out when Units are given as it follows from them.
+	Grav float64 `json:"grav,omitempty"`
+	// Units are the units the simulation is run in, such as
+	// simulation.UnitsAstro, and InputUnits those the rest of
+	// the request is in when they differ.
+	Units            string               `json:"units,omitempty"`
+	InputUnits       string               `json:"inputUnits,omitempty"`
+	Theta            float64              `json:"theta"`
+	Opening          string               `json:"opening,omitempty"`
+	Alpha            float64              `json:"alpha,omitempty"`
+	Order            int                  `json:"order,omitempty"`
+	Softening        float64              `json:"softening,omitempty"`
+	Kernel           string               `json:"kernel,omitempty"`
+	Workers          int                  `json:"workers,omitempty"`
+	LeafCapacity     int                  `json:"leafCapacity,omitempty"`
+	TreeReuse        bool                 `json:"treeReuse,omitempty"`
+	RebuildThreshold float64              `json:"rebuildThreshold,omitempty"`
+	Dt               float64              `json:"dt,omitempty"`
+	Timestep         *simulation.Timestep `json:"timestep,omitempty"`
+	BlockLevels      int                  `json:"blockLevels,omitempty"`
+	Integrator       string               `json:"integrator,omitempty"`
+	Collisions       string               `json:"collisions,omitempty"`
+	Restitution      float64              `json:"restitution,omitempty"`
+	Fragments        int                  `json:"fragments,omitempty"`
+	Seed             int64                `json:"seed,omitempty"`
+	DiagnosticsEvery int                  `json:"diagnosticsEvery,omitempty"`
+	Bodies           []simulation.Body    `json:"bodies,omitempty"`
+	// Generator generates the bodies from a model instead
+	// of them being listed in Bodies.
+	Generator *generate.Spec `json:"generator,omitempty"`
+	// Scenario combines several systems on orbits around
+	// each other instead of the bodies being listed in Bodies.
+	Scenario *generate.Scenario `json:"scenario,omitempty"`
 }
 
 type NewSimulationResponse struct {
@@ -32,19 +67,22 @@ type StartSimulationResponse struct {
 }
 
 // StatusSimulationResponse is response object for the /status endpoint.
-// ID represent the id of the job and step the no of steps
+// ID represent the id of the job, step the no of steps and time the
+// amount of simulated time that has passed
 type StatusSimulationResponse struct {
-	ID   string `json:"id"`
-	Step int    `json:"step"`
+	ID   string  `json:"id"`
+	Step int     `json:"step"`
+	Time float64 `json:"time"`
+	// Running is true while the simulation is running and
+	// Progress reports how far it has got.
+	Running  bool                 `json:"running,omitempty"`
+	Progress *simulation.Progress `json:"progress,omitempty"`
 }
 
 // newSimulation is called when a request is made to "/simulation/new".
 // It creates a new simulation with a unique ID and then returns the
 // details of the simulation to the requester.
 func (a *API) newSimulation(w http.ResponseWriter, r *http.Request) {
-	a.mutex.Lock()
-	defer a.mutex.Unlock()
-
 	fmt.Println("New Simulation Request")
 	// Read in therequest body
 
@@ -55,6 +93,112 @@ func (a *API) newSimulation(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The bodies are listed, generated or come from a scenario
+	sources := 0
+	for _, given := range []bool{len(req.Bodies) > 0, req.Generator != nil, req.Scenario != nil} {
+		if given {
+			sources++
+		}
+	}
+	if sources > 1 {
+		http.Error(w, fmt.Errorf("only one of bodies, a generator or a scenario can be given").Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Generating the bodies takes time and memory in
+	// proportion to their number, so it is checked first
+	n := len(req.Bodies)
+	if req.Generator != nil {
+		n = req.Generator.Count()
+	} else if req.Scenario != nil {
+		n = req.Scenario.Count()
+	}
+	if n > MaxBodies {
+		http.Error(w, fmt.Errorf("a simulation can have at most %d bodies", MaxBodies).Error(), http.StatusBadRequest)
+		return
+	}
+
+	// The request is read in its input units, which give the
+	// gravitational constant when it is left out
+	input := req.InputUnits
+	if input == "" {
+		input = req.Units
+	} else if req.Units == "" {
+		http.Error(w, fmt.Errorf("input units can only be given along with units").Error(), http.StatusBadRequest)
+		return
+	}
+	grav := req.Grav
+	if input != "" && grav == 0 {
+		units, err := simulation.NewUnits(input)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		grav = units.G()
+	}
+
+	if err := solarUnits(req, input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	bodies := req.Bodies
+	if req.Generator != nil {
+		bodies, err = generate.Generate(*req.Generator, grav)
+	} else if req.Scenario != nil {
+		bodies, err = req.Scenario.Bodies(grav)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Check the simulation can be run before storing it
+	sim := simulation.NewSimulation(grav, req.Theta, bodies...)
+	sim.Units = input
+	if req.Opening != "" {
+		sim.Opening = req.Opening
+	}
+	sim.Alpha = req.Alpha
+	sim.Order = req.Order
+	sim.Softening = req.Softening
+	if req.Kernel != "" {
+		sim.Kernel = req.Kernel
+	}
+	sim.Workers = req.Workers
+	sim.LeafCapacity = req.LeafCapacity
+	sim.TreeReuse = req.TreeReuse
+	sim.RebuildThreshold = req.RebuildThreshold
+	if req.Dt > 0 {
+		sim.Dt = req.Dt
+	}
+	sim.Timestep = req.Timestep
+	sim.BlockLevels = req.BlockLevels
+	if req.Integrator != "" {
+		sim.Integrator = req.Integrator
+	}
+	sim.Collisions = req.Collisions
+	sim.Restitution = req.Restitution
+	sim.Fragments = req.Fragments
+	sim.Seed = req.Seed
+	sim.DiagnosticsEvery = req.DiagnosticsEvery
+	if err := sim.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Units != input {
+		if sim, err = sim.Convert(req.Units); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// The simulation is only stored with the lock held, so
+	// requests for other simulations are not held up while
+	// it is made. Its first checkpoint is written with it, so
+	// a deletion cannot come between them.
+	a.mutex.Lock()
+
 	// Find a new simulation ID/
 	// I think this will timeout when the
 	// WriteTimeout limit is reached.
@@ -67,20 +211,46 @@ func (a *API) newSimulation(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Create a new simulation
-	a.simulations[id] = simulation.NewSimulation(req.Grav, req.Theta, req.Bodies...)
+	a.simulations[id] = sim
+	a.checkpoint(id, sim)
+	a.mutex.Unlock()
 
-	// Send simulation information back to the requester
+	// Send simulation information back to the requester. A
+	// run works on a copy, so the simulation is not changed
+	// while it is sent.
 	w.WriteHeader(http.StatusOK)
 	w.Header().Set("Content-Type", "application/json")
 
 	json.NewEncoder(w).Encode(
 		NewSimulationResponse{
 			ID:         id,
-			Simulation: a.simulations[id],
+			Simulation: sim,
 		},
 	)
 }
 
+// solarUnits returns an error if the request generates the solar
+// system, which is always in astronomical units, when its input
+// units are different.
+func solarUnits(req NewSimulationRequest, input string) error {
+	if input == "" || input == simulation.UnitsAstro {
+		return nil
+	}
+
+	specs := []*generate.Spec{req.Generator}
+	if req.Scenario != nil {
+		for _, system := range req.Scenario.Systems {
+			specs = append(specs, system.Spec)
+		}
+	}
+	for _, spec := range specs {
+		if spec != nil && spec.Model == generate.ModelSolar {
+			return fmt.Errorf("the solar system is in %s units, so cannot be generated with %s input units", simulation.UnitsAstro, input)
+		}
+	}
+	return nil
+}
+
 // start is called when a request is made to "/simulation/start/{simID}/{steps}".
 // This will start the simulation with the specified ID for
 // a certain number of steps.
@@ -102,13 +272,14 @@ func (a *API) start(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	a.mutex.RLock()
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
 	// Check if a simulation has been created before
 	if _, ok := a.simulations[simID]; !ok {
 		http.Error(w, fmt.Errorf("there is no simulation with the simID %s", simID).Error(), http.StatusNotFound)
 		return
 	}
-	a.mutex.RUnlock()
 
 	// Steps must be positive
 	if steps <= 0 {
@@ -116,30 +287,13 @@ func (a *API) start(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Perform the number of steps on the simulation
-	go func() {
-		// Make a deep copy of the simulation targeted
-		a.mutex.RLock()
-		sim := a.simulations[simID]
-		sim.Bodies = make([]simulation.Body, len(a.simulations[simID].Bodies))
-		copy(sim.Bodies, a.simulations[simID].Bodies)
-		a.mutex.RUnlock()
-
-		sim.Steps(steps)
+	// A simulation can only be run once at a time
+	if _, running := a.runs[simID]; running {
+		http.Error(w, fmt.Errorf("the simulation with the simID %s is already running", simID).Error(), http.StatusConflict)
+		return
+	}
 
-		// Check if it has not been deleted during processing
-		a.mutex.RLock()
-		if _, ok := a.simulations[simID]; !ok {
-			a.mutex.RUnlock()
-			return
-		}
-		a.mutex.RUnlock()
-
-		// Once this is done copy back to the original
-		a.mutex.Lock()
-		defer a.mutex.Unlock()
-		copy(a.simulations[simID].Bodies, sim.Bodies)
-	}()
+	a.run(simID, steps)
 
 	w.WriteHeader(http.StatusOK)
 	w.Header().Set("Content-Type", "application/json")
@@ -152,6 +306,85 @@ func (a *API) start(w http.ResponseWriter, r *http.Request) {
 	)
 }
 
+// run starts the simulation with the ID simID running for the
+// number of steps given in the background, replacing it once
+// they have been taken. The mutex must be held.
+func (a *API) run(simID string, steps int) {
+	// Run a copy of the simulation so it can still be read
+	// while it runs
+	sim := a.simulations[simID].Clone()
+	ctx, cancel := context.WithCancel(context.Background())
+	current := &run{cancel: cancel}
+	a.runs[simID] = current
+	a.saveRun(simID, sim.Step+steps)
+
+	// Perform the number of steps on the simulation
+	a.checkpoints.running.Add(1)
+	go func() {
+		defer a.checkpoints.running.Done()
+		defer cancel()
+
+		saved := time.Now()
+		panicked := false
+		err := func() (err error) {
+			// A panic in a step stops the run rather than
+			// the server
+			defer func() {
+				if p := recover(); p != nil {
+					panicked = true
+					err = fmt.Errorf("panic: %v\n%s", p, debug.Stack())
+				}
+			}()
+
+			return sim.Run(ctx, steps, func(p simulation.Progress) {
+				a.mutex.Lock()
+				defer a.mutex.Unlock()
+				if r, ok := a.runs[simID]; ok {
+					r.progress = p
+				}
+
+				// Save the run every so often, unless the
+				// simulation has been removed
+				if _, ok := a.simulations[simID]; ok && time.Since(saved) >= a.checkpoints.interval {
+					a.checkpoint(simID, sim)
+					saved = time.Now()
+				}
+			})
+		}()
+		if err != nil {
+			log.Printf("simulation %s: %v", simID, err)
+		}
+
+		a.mutex.Lock()
+		defer a.mutex.Unlock()
+		delete(a.runs, simID)
+
+		// The run is only resumed after a restart when it was
+		// interrupted by the server shutting down
+		if !current.interrupted {
+			a.removeRun(simID)
+		}
+
+		// A simulation which panicked may have been left part
+		// of the way through a step, so the original is kept.
+		// A periodic checkpoint may have saved the broken run
+		// over it, so the original is saved again.
+		if panicked {
+			if original, ok := a.simulations[simID]; ok {
+				a.checkpoint(simID, original)
+			}
+			return
+		}
+
+		// Check if it has not been deleted during processing,
+		// then replace the original with the steps taken
+		if _, ok := a.simulations[simID]; ok {
+			a.simulations[simID] = sim
+			a.checkpoint(simID, sim)
+		}
+	}()
+}
+
 // status is called when a request is made to "/simulation/status/{simID}".
 // This endpoint will return the status of the simulation with
 // the specified simulation ID.
@@ -163,21 +396,126 @@ func (a *API) status(w http.ResponseWriter, r *http.Request) {
 	simID := vars["simID"]
 
 	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
 	// Check if a simulation has been created before
 	sim, ok := a.simulations[simID]
 	if !ok {
 		http.Error(w, fmt.Errorf("there is no simulation with the simID %s", simID).Error(), http.StatusNotFound)
 		return
 	}
-	a.mutex.RUnlock()
+
+	response := StatusSimulationResponse{
+		ID:   simID,
+		Step: sim.Step,
+		Time: sim.Time,
+	}
+
+	// A running simulation has moved on to the last step
+	// its run has taken
+	if r, running := a.runs[simID]; running {
+		progress := r.progress
+		response.Running = true
+		response.Progress = &progress
+		if progress.Done > 0 {
+			response.Step = progress.Step
+			response.Time = progress.Time
+		}
+	}
+
+	if units := r.FormValue("units"); units != "" {
+		_, _, scale, err := sim.Scale(units)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		response.Time *= scale
+		if response.Progress != nil {
+			response.Progress.Time *= scale
+		}
+	}
 
 	w.WriteHeader(http.StatusOK)
 	w.Header().Set("Content-Type", "application/json")
 	// Report the current status
+	json.NewEncoder(w).Encode(response)
+}
+
+// stop is called when a request is made to "/simulation/stop/{simID}".
+// It stops the simulation with the specified simulation ID once the
+// step it is taking has finished, keeping the steps it has taken.
+func (a *API) stop(w http.ResponseWriter, r *http.Request) {
+	log.Println(r.URL.Path)
+
+	// Retrieve path parameters
+	vars := mux.Vars(r)
+	simID := vars["simID"]
+
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	// Check if a simulation has been created before
+	if _, ok := a.simulations[simID]; !ok {
+		http.Error(w, fmt.Errorf("there is no simulation with the simID %s", simID).Error(), http.StatusNotFound)
+		return
+	}
+
+	current, running := a.runs[simID]
+	if !running {
+		http.Error(w, fmt.Errorf("the simulation with the simID %s is not running", simID).Error(), http.StatusConflict)
+		return
+	}
+	current.cancel()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(
-		StatusSimulationResponse{
-			ID:   simID,
-			Step: sim.Step,
+		StartSimulationResponse{
+			ID:     simID,
+			Status: "stopping",
+		},
+	)
+}
+
+// DiagnosticsSimulationResponse is the response object for the
+// /diagnostics endpoint. It holds the conserved quantities of the
+// simulation recorded as it ran.
+type DiagnosticsSimulationResponse struct {
+	ID          string                   `json:"id"`
+	Diagnostics []simulation.Diagnostics `json:"diagnostics"`
+}
+
+// diagnostics is called when a request is made to "/simulation/diagnostics/{simID}".
+// This endpoint will return the energy, momentum and other diagnostics
+// recorded for the simulation with the specified simulation ID.
+func (a *API) diagnostics(w http.ResponseWriter, r *http.Request) {
+	log.Println(r.URL.Path)
+
+	// Retrieve path parameters
+	vars := mux.Vars(r)
+	simID := vars["simID"]
+
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	// Check if a simulation has been created before
+	sim, ok := a.simulations[simID]
+	if !ok {
+		http.Error(w, fmt.Errorf("there is no simulation with the simID %s", simID).Error(), http.StatusNotFound)
+		return
+	}
+	sim, err := inUnits(sim, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(
+		DiagnosticsSimulationResponse{
+			ID:          simID,
+			Diagnostics: sim.Diagnostics,
 		},
 	)
 }
@@ -206,10 +544,15 @@ func (a *API) results(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, fmt.Sprintf("simulation with id %s not present", simID), http.StatusBadRequest)
 		return
 	}
+	sim, err := inUnits(sim, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 
-	err := json.NewEncoder(w).Encode(
+	err = json.NewEncoder(w).Encode(
 		simulationResultResponse{
 			Simulation: sim,
 		},
@@ -242,6 +585,219 @@ func (a *API) remove(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Stop the simulation if it is running
+	if current, running := a.runs[simID]; running {
+		current.cancel()
+	}
+
 	delete(a.simulations, simID)
+	a.removeCheckpoint(simID)
 	w.WriteHeader(http.StatusOK)
 }
+
+// accuracy is called when a request is made to "/simulation/accuracy/{simID}".
+// This endpoint compares the forces the oct tree gives the bodies of the
+// simulation with the ID specified against direct summation. The optional
+// "theta" query parameter replaces the simulation's opening angle.
+func (a *API) accuracy(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	simID, hasSimID := vars["simID"]
+	if !hasSimID {
+		http.Error(w, "simulation id not provided", http.StatusBadRequest)
+		return
+	}
+
+	a.mutex.RLock()
+	sim, present := a.simulations[simID]
+	if !present {
+		a.mutex.RUnlock()
+		http.Error(w, fmt.Sprintf("simulation with id %s not present", simID), http.StatusBadRequest)
+		return
+	}
+
+	// Work on a copy so the report does not hold up
+	// the simulation
+	s := sim.Clone()
+	a.mutex.RUnlock()
+
+	s, err := inUnits(s, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if theta := r.FormValue("theta"); theta != "" {
+		s.Theta, err = strconv.ParseFloat(theta, 64)
+		if err != nil {
+			http.Error(w, fmt.Errorf("the 'theta' parameter must be a number").Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := s.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	err = json.NewEncoder(w).Encode(s.ForceAccuracy())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// TreeSimulationResponse is the response of the /tree endpoint.
+// It holds the statistics and nodes of the oct tree built from
+// the simulation's current bodies.
+type TreeSimulationResponse struct {
+	ID    string                `json:"id"`
+	Step  int                   `json:"step"`
+	Stats simulation.TreeStats  `json:"stats"`
+	Nodes []simulation.TreeNode `json:"nodes"`
+}
+
+// tree is called when a request is made to "/simulation/tree/{simID}".
+// It returns a snapshot of the oct tree of the simulation's bodies,
+// as JSON or, when the 'format' parameter is "binary", in the compact
+// binary form of simulation.Octree.MarshalBinary.
+func (a *API) tree(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	simID := vars["simID"]
+
+	format := r.FormValue("format")
+	if format != "" && format != "json" && format != "binary" {
+		http.Error(w, fmt.Errorf("the 'format' parameter must be json or binary").Error(), http.StatusBadRequest)
+		return
+	}
+
+	a.mutex.RLock()
+	sim, present := a.simulations[simID]
+	if !present {
+		a.mutex.RUnlock()
+		http.Error(w, fmt.Sprintf("simulation with id %s not present", simID), http.StatusBadRequest)
+		return
+	}
+	sim, err := inUnits(sim, r)
+	if err != nil {
+		a.mutex.RUnlock()
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Build the tree from a copy of the bodies so the
+	// simulation is not held up
+	s := simulation.NewSimulation(sim.Grav, sim.Theta, sim.Bodies...)
+	s.Workers = sim.Workers
+	s.LeafCapacity = sim.LeafCapacity
+	s.Step = sim.Step
+	a.mutex.RUnlock()
+
+	tree := s.Tree()
+
+	if format == "binary" {
+		data, err := tree.MarshalBinary()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(data)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(
+		TreeSimulationResponse{
+			ID:    simID,
+			Step:  s.Step,
+			Stats: tree.Stats(),
+			Nodes: tree.Export(),
+		},
+	)
+}
+
+// BodyElements holds the orbital elements of a body.
+type BodyElements struct {
+	Name     string            `json:"name"`
+	Elements generate.Elements `json:"elements"`
+}
+
+// ElementsSimulationResponse is the response of the /elements
+// endpoint. It holds the orbital elements of each body around
+// the central body.
+type ElementsSimulationResponse struct {
+	ID      string         `json:"id"`
+	Step    int            `json:"step"`
+	Central string         `json:"central"`
+	Bodies  []BodyElements `json:"bodies"`
+}
+
+// elements is called when a request is made to "/simulation/elements/{simID}".
+// It returns the orbital elements of the simulation's bodies around the
+// body named by the 'central' parameter, or the most massive body when
+// it is not given. Bodies falling straight towards or away from the
+// central body have no orbit and are left out.
+func (a *API) elements(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	simID := vars["simID"]
+
+	a.mutex.RLock()
+	sim, present := a.simulations[simID]
+	if !present {
+		a.mutex.RUnlock()
+		http.Error(w, fmt.Sprintf("simulation with id %s not present", simID), http.StatusBadRequest)
+		return
+	}
+	sim, err := inUnits(sim, r)
+	if err != nil {
+		a.mutex.RUnlock()
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Work on a copy of the bodies so the simulation is
+	// not held up
+	bodies := append([]simulation.Body(nil), sim.Bodies...)
+	grav, step := sim.Grav, sim.Step
+	a.mutex.RUnlock()
+
+	name := r.FormValue("central")
+	central := -1
+	for i := range bodies {
+		if name != "" && bodies[i].Name == name {
+			central = i
+			break
+		}
+		if name == "" && (central < 0 || bodies[i].Mass() > bodies[central].Mass()) {
+			central = i
+		}
+	}
+	if central < 0 {
+		http.Error(w, fmt.Errorf("there is no central body %q", name).Error(), http.StatusBadRequest)
+		return
+	}
+
+	response := ElementsSimulationResponse{
+		ID:      simID,
+		Step:    step,
+		Central: bodies[central].Name,
+		Bodies:  []BodyElements{},
+	}
+	for i, b := range bodies {
+		if i == central {
+			continue
+		}
+
+		e := generate.ElementsOf(b, bodies[central], grav)
+		if !finite(e.SemiMajorAxis, e.Eccentricity, e.Inclination, e.Node, e.Periapsis, e.MeanAnomaly) {
+			continue
+		}
+		response.Bodies = append(response.Bodies, BodyElements{Name: b.Name, Elements: e})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
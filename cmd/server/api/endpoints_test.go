@@ -71,7 +71,7 @@ func statusSimul(baseURL string){
 		body        string
 	}{
 		{expected: http.StatusOK, given: fmt.Sprintf("/%s", res.ID),
-			description: "Existent simulation", body: fmt.Sprintf("{\"id\":\"%s\",\"step\":0}\n",
+			description: "Existent simulation", body: fmt.Sprintf("{\"id\":\"%s\",\"step\":0,\"time\":0}\n",
 			res.ID)},
 	}...)
 }
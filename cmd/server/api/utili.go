@@ -1,6 +1,12 @@
 package api
 
-import "math/rand"
+import (
+	"math"
+	"math/rand"
+	"net/http"
+
+	"github.com/tardisman5197/barnes-hut-sim/pkg/simulation"
+)
 
 // randomID returns a string of the length specified
 // with random capital chracters.
@@ -13,3 +19,24 @@ func randomID(length int) string {
 
 	return string(id)
 }
+
+// finite reports whether none of the values are infinite
+// or NaN.
+func finite(values ...float64) bool {
+	for _, v := range values {
+		if math.IsInf(v, 0) || math.IsNaN(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// inUnits returns sim converted to the units named by the
+// request's units parameter, or sim itself when there is none.
+func inUnits(sim *simulation.Simulation, r *http.Request) (*simulation.Simulation, error) {
+	units := r.FormValue("units")
+	if units == "" {
+		return sim, nil
+	}
+	return sim.Convert(units)
+}
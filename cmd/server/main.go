@@ -2,7 +2,9 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
+	"log"
 	"os"
 	"os/signal"
 	"syscall"
@@ -12,9 +14,19 @@ import (
 )
 
 func main() {
+	checkpoints := flag.String("checkpoints", "", "directory the simulations are checkpointed to and restored from")
+	interval := flag.Duration("checkpoint-interval", api.DefaultCheckpointInterval, "how often running simulations are checkpointed")
+	flag.Parse()
+
 	fmt.Println("Creating API")
 	// Create an API instance
 	a := api.NewAPI()
+	if *checkpoints != "" {
+		if err := a.EnableCheckpoints(*checkpoints, *interval); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("Checkpointing to", *checkpoints)
+	}
 	apiDone := a.Listen()
 	fmt.Println("Listening")
 
@@ -0,0 +1,65 @@
+package generate
+
+import "math"
+
+// The modified Bessel functions below use the polynomial
+// approximations of Abramowitz and Stegun (9.8.1 to 9.8.8),
+// accurate to around 1e-7. They are scaled so that their
+// products stay finite far from the origin: i0e and i1e are
+// multiplied by e^-x and k0e and k1e by e^x.
+
+// i0e returns I0(x) e^-x for x >= 0.
+func i0e(x float64) float64 {
+	if x < 3.75 {
+		y := (x / 3.75) * (x / 3.75)
+		return math.Exp(-x) * (1 + y*(3.5156229+y*(3.0899424+y*(1.2067492+
+			y*(0.2659732+y*(0.360768e-1+y*0.45813e-2))))))
+	}
+
+	y := 3.75 / x
+	return (0.39894228 + y*(0.1328592e-1+y*(0.225319e-2+y*(-0.157565e-2+
+		y*(0.916281e-2+y*(-0.2057706e-1+y*(0.2635537e-1+y*(-0.1647633e-1+
+			y*0.392377e-2)))))))) / math.Sqrt(x)
+}
+
+// i1e returns I1(x) e^-x for x >= 0.
+func i1e(x float64) float64 {
+	if x < 3.75 {
+		y := (x / 3.75) * (x / 3.75)
+		return math.Exp(-x) * x * (0.5 + y*(0.87890594+y*(0.51498869+
+			y*(0.15084934+y*(0.2658733e-1+y*(0.301532e-2+y*0.32411e-3))))))
+	}
+
+	y := 3.75 / x
+	p := 0.2282967e-1 + y*(-0.2895312e-1+y*(0.1787654e-1-y*0.420059e-2))
+	return (0.39894228 + y*(-0.3988024e-1+y*(-0.362018e-2+y*(0.163801e-2+
+		y*(-0.1031555e-1+y*p))))) / math.Sqrt(x)
+}
+
+// k0e returns K0(x) e^x for x > 0.
+func k0e(x float64) float64 {
+	if x <= 2 {
+		y := x * x / 4
+		return math.Exp(x) * (-math.Log(x/2)*i0e(x)*math.Exp(x) + (-0.57721566 +
+			y*(0.42278420+y*(0.23069756+y*(0.3488590e-1+y*(0.262698e-2+
+				y*(0.10750e-3+y*0.74e-5)))))))
+	}
+
+	y := 2 / x
+	return (1.25331414 + y*(-0.7832358e-1+y*(0.2189568e-1+y*(-0.1062446e-1+
+		y*(0.587872e-2+y*(-0.251540e-2+y*0.53208e-3)))))) / math.Sqrt(x)
+}
+
+// k1e returns K1(x) e^x for x > 0.
+func k1e(x float64) float64 {
+	if x <= 2 {
+		y := x * x / 4
+		return math.Exp(x) * (math.Log(x/2)*i1e(x)*math.Exp(x) + (1/x)*(1+
+			y*(0.15443144+y*(-0.67278579+y*(-0.18156897+y*(-0.1919402e-1+
+				y*(-0.110404e-2+y*-0.4686e-4)))))))
+	}
+
+	y := 2 / x
+	return (1.25331414 + y*(0.23498619+y*(-0.3655620e-1+y*(0.1504268e-1+
+		y*(-0.780353e-2+y*(0.325614e-2+y*-0.68245e-3)))))) / math.Sqrt(x)
+}
@@ -0,0 +1,219 @@
+package generate
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/tardisman5197/barnes-hut-sim/pkg/simulation"
+)
+
+// keplerTolerance is how closely Kepler's equation is solved.
+const keplerTolerance = 1e-14
+
+// Elements are the Keplerian orbital elements of a body
+// around a central body. The angles are in radians and
+// measured in the frame of the simulation, with the xy plane
+// as the reference plane and the x axis as the reference
+// direction.
+type Elements struct {
+	// SemiMajorAxis is positive for an elliptical orbit and
+	// negative for a hyperbolic one.
+	SemiMajorAxis float64 `json:"a"`
+	// Eccentricity is below 1 for an elliptical orbit and
+	// above 1 for a hyperbolic one.
+	Eccentricity float64 `json:"e"`
+	// Inclination is the angle between the orbit and the
+	// xy plane, from 0 to π, above π/2 being retrograde.
+	Inclination float64 `json:"i"`
+	// Node is the longitude of the ascending node, Ω.
+	Node float64 `json:"node"`
+	// Periapsis is the argument of periapsis, ω.
+	Periapsis float64 `json:"periapsis"`
+	// MeanAnomaly is the mean anomaly, M.
+	MeanAnomaly float64 `json:"meanAnomaly"`
+}
+
+// Validate checks the elements describe an orbit. Parabolic
+// orbits, with an eccentricity of exactly 1, cannot be
+// described by a semi-major axis.
+func (e Elements) Validate() error {
+	switch {
+	case e.Eccentricity < 0:
+		return fmt.Errorf("the eccentricity must not be negative")
+	case e.Eccentricity == 1:
+		return fmt.Errorf("parabolic orbits are not supported")
+	case e.Eccentricity < 1 && e.SemiMajorAxis <= 0:
+		return fmt.Errorf("an elliptical orbit must have a positive semi-major axis")
+	case e.Eccentricity > 1 && e.SemiMajorAxis >= 0:
+		return fmt.Errorf("a hyperbolic orbit must have a negative semi-major axis")
+	case e.Inclination < 0 || e.Inclination > math.Pi:
+		return fmt.Errorf("the inclination must be between 0 and π")
+	}
+	return nil
+}
+
+// State returns the position and velocity on the orbit,
+// relative to the central body, with mu the gravitational
+// constant times the mass of the central body and the body
+// orbiting it.
+func (e Elements) State(mu float64) (pos, vel simulation.Vector) {
+	ecc := e.Eccentricity
+	a := math.Abs(e.SemiMajorAxis)
+	n := math.Sqrt(mu / (a * a * a))
+
+	// Find the position and velocity in the plane of the
+	// orbit, with periapsis along the x axis
+	var x, y, vx, vy float64
+	if ecc < 1 {
+		E := eccentricAnomaly(e.MeanAnomaly, ecc)
+		sin, cos := math.Sincos(E)
+		d := 1 - ecc*cos
+		x, y = a*(cos-ecc), a*math.Sqrt(1-ecc*ecc)*sin
+		vx, vy = -a*n*sin/d, a*n*math.Sqrt(1-ecc*ecc)*cos/d
+	} else {
+		H := hyperbolicAnomaly(e.MeanAnomaly, ecc)
+		sinh, cosh := math.Sinh(H), math.Cosh(H)
+		d := ecc*cosh - 1
+		x, y = a*(ecc-cosh), a*math.Sqrt(ecc*ecc-1)*sinh
+		vx, vy = -a*n*sinh/d, a*n*math.Sqrt(ecc*ecc-1)*cosh/d
+	}
+
+	p, q := e.axes()
+	return p.Scale(x).Add(q.Scale(y)), p.Scale(vx).Add(q.Scale(vy))
+}
+
+// axes returns the unit vectors towards periapsis, p, and a
+// quarter of the orbit on from it, q.
+func (e Elements) axes() (p, q simulation.Vector) {
+	sinO, cosO := math.Sincos(e.Node)
+	sinI, cosI := math.Sincos(e.Inclination)
+	sinW, cosW := math.Sincos(e.Periapsis)
+
+	p = simulation.Vector{
+		X: cosO*cosW - sinO*sinW*cosI,
+		Y: sinO*cosW + cosO*sinW*cosI,
+		Z: sinW * sinI,
+	}
+	q = simulation.Vector{
+		X: -cosO*sinW - sinO*cosW*cosI,
+		Y: -sinO*sinW + cosO*cosW*cosI,
+		Z: cosW * sinI,
+	}
+	return p, q
+}
+
+// ElementsFromState returns the orbital elements of the orbit
+// with the given position and velocity relative to the central
+// body, with mu the gravitational constant times the mass of
+// both bodies. The node of an orbit in the xy plane is taken to
+// be along the x axis, and the periapsis of a circular orbit to
+// be at its node.
+func ElementsFromState(pos, vel simulation.Vector, mu float64) Elements {
+	const small = 1e-12
+
+	r := pos.Length()
+	h := pos.Cross(vel)
+	hUnit := h.Scale(1 / h.Length())
+
+	// The node is along z × h, which is 0 for an orbit in
+	// the xy plane
+	node := simulation.Vector{X: -h.Y, Y: h.X}
+	if node.Length() <= small*h.Length() {
+		node = simulation.Vector{X: 1}
+	}
+	node = node.Scale(1 / node.Length())
+
+	eccentricity := pos.Scale(vel.Dot(vel) - mu/r).Sub(vel.Scale(pos.Dot(vel))).Scale(1 / mu)
+	ecc := eccentricity.Length()
+
+	elements := Elements{
+		SemiMajorAxis: 1 / (2/r - vel.Dot(vel)/mu),
+		Eccentricity:  ecc,
+		Inclination:   math.Acos(math.Max(-1, math.Min(1, hUnit.Z))),
+		Node:          angle(math.Atan2(node.Y, node.X)),
+	}
+
+	// Angles in the plane of the orbit are measured from the
+	// node towards the direction of motion
+	across := hUnit.Cross(node)
+	periapsis := node
+	if ecc > small {
+		elements.Periapsis = angle(math.Atan2(eccentricity.Dot(across), eccentricity.Dot(node)))
+		periapsis = eccentricity.Scale(1 / ecc)
+	}
+
+	beyond := hUnit.Cross(periapsis)
+	nu := math.Atan2(pos.Dot(beyond), pos.Dot(periapsis))
+
+	sin, cos := math.Sincos(nu)
+	if ecc < 1 {
+		E := math.Atan2(math.Sqrt(1-ecc*ecc)*sin, ecc+cos)
+		elements.MeanAnomaly = angle(E - ecc*math.Sin(E))
+	} else {
+		H := math.Asinh(math.Sqrt(ecc*ecc-1) * sin / (1 + ecc*cos))
+		elements.MeanAnomaly = ecc*math.Sinh(H) - H
+	}
+	return elements
+}
+
+// Place returns body on the orbit with the given elements
+// around central, keeping the body's name, radius and density,
+// with grav the gravitational constant of the simulation.
+func Place(body, central simulation.Body, elements Elements, grav float64) simulation.Body {
+	pos, vel := elements.State(grav * (central.Mass() + body.Mass()))
+	body.X, body.Y, body.Z = central.X+pos.X, central.Y+pos.Y, central.Z+pos.Z
+	body.VX, body.VY, body.VZ = central.VX+vel.X, central.VY+vel.Y, central.VZ+vel.Z
+	return body
+}
+
+// ElementsOf returns the orbital elements of body around
+// central, with grav the gravitational constant of the
+// simulation.
+func ElementsOf(body, central simulation.Body, grav float64) Elements {
+	pos := simulation.Vector{X: body.X - central.X, Y: body.Y - central.Y, Z: body.Z - central.Z}
+	vel := simulation.Vector{X: body.VX - central.VX, Y: body.VY - central.VY, Z: body.VZ - central.VZ}
+	return ElementsFromState(pos, vel, grav*(central.Mass()+body.Mass()))
+}
+
+// eccentricAnomaly solves Kepler's equation, M = E - e sin E,
+// for the eccentric anomaly E by Newton's method.
+func eccentricAnomaly(M, e float64) float64 {
+	M = math.Remainder(M, 2*math.Pi)
+	E := M
+	if e > 0.8 {
+		E = math.Copysign(math.Pi, M)
+	}
+
+	for i := 0; i < 64; i++ {
+		step := (E - e*math.Sin(E) - M) / (1 - e*math.Cos(E))
+		E -= step
+		if math.Abs(step) < keplerTolerance {
+			break
+		}
+	}
+	return E
+}
+
+// hyperbolicAnomaly solves the hyperbolic Kepler's equation,
+// M = e sinh H - H, for the hyperbolic anomaly H by Newton's
+// method.
+func hyperbolicAnomaly(M, e float64) float64 {
+	H := math.Asinh(M / e)
+	for i := 0; i < 128; i++ {
+		step := (e*math.Sinh(H) - H - M) / (e*math.Cosh(H) - 1)
+		H -= step
+		if math.Abs(step) < keplerTolerance*math.Max(1, math.Abs(H)) {
+			break
+		}
+	}
+	return H
+}
+
+// angle returns the angle equal to a in [0, 2π).
+func angle(a float64) float64 {
+	a = math.Mod(a, 2*math.Pi)
+	if a < 0 {
+		a += 2 * math.Pi
+	}
+	return a
+}
@@ -0,0 +1,108 @@
+package generate
+
+import (
+	"math"
+	"testing"
+
+	"github.com/tardisman5197/barnes-hut-sim/pkg/simulation"
+)
+
+func TestElementsRoundTrip(t *testing.T) {
+	tests := []Elements{
+		{SemiMajorAxis: 1, Eccentricity: 0.3, Inclination: 0.4, Node: 1, Periapsis: 2, MeanAnomaly: 3},
+		{SemiMajorAxis: 5, Eccentricity: 0.95, Inclination: 2.5, Node: 4, Periapsis: 0.5, MeanAnomaly: 0.1},
+		{SemiMajorAxis: -2, Eccentricity: 1.5, Inclination: 1, Node: 0.3, Periapsis: 5, MeanAnomaly: -1.2},
+		{SemiMajorAxis: 3, Eccentricity: 0.1, Inclination: 0, Node: 0, Periapsis: 1, MeanAnomaly: 6},
+		{SemiMajorAxis: 2, Eccentricity: 0, Inclination: 0.2, Node: 3, Periapsis: 0, MeanAnomaly: 1},
+	}
+
+	for _, e := range tests {
+		if err := e.Validate(); err != nil {
+			t.Fatal(err)
+		}
+
+		pos, vel := e.State(2)
+		got := ElementsFromState(pos, vel, 2)
+
+		want := []float64{e.SemiMajorAxis, e.Eccentricity, e.Inclination, e.Node, e.Periapsis, e.MeanAnomaly}
+		have := []float64{got.SemiMajorAxis, got.Eccentricity, got.Inclination, got.Node, got.Periapsis, got.MeanAnomaly}
+		for i := range want {
+			if math.Abs(want[i]-have[i]) > 1e-9*math.Max(1, math.Abs(want[i])) {
+				t.Fatalf("expected %+v, got %+v", e, got)
+			}
+		}
+
+		// The state on the orbit found is the same
+		pos2, vel2 := got.State(2)
+		if pos2.Sub(pos).Length() > 1e-9 || vel2.Sub(vel).Length() > 1e-9 {
+			t.Fatalf("expected the same state for %+v", e)
+		}
+	}
+}
+
+func TestElementsInvalid(t *testing.T) {
+	tests := []Elements{
+		{SemiMajorAxis: 1, Eccentricity: -0.1},
+		{SemiMajorAxis: 1, Eccentricity: 1},
+		{SemiMajorAxis: -1, Eccentricity: 0.5},
+		{SemiMajorAxis: 1, Eccentricity: 1.5},
+		{SemiMajorAxis: 1, Eccentricity: 0.5, Inclination: 4},
+	}
+
+	for _, e := range tests {
+		if err := e.Validate(); err == nil {
+			t.Fatalf("expected an error for %+v", e)
+		}
+	}
+}
+
+func TestSolarSystem(t *testing.T) {
+	bodies := SolarSystem(SolarGrav)
+	if len(bodies) != 9 || bodies[0].Name != "Sun" || bodies[3].Name != "Earth" {
+		t.Fatalf("unexpected bodies %+v", bodies)
+	}
+
+	earth := ElementsOf(bodies[3], bodies[0], SolarGrav)
+	if math.Abs(earth.SemiMajorAxis-1) > 1e-5 || math.Abs(earth.Eccentricity-0.0167) > 1e-4 {
+		t.Fatalf("unexpected elements of the Earth %+v", earth)
+	}
+
+	// After a year the Earth is back where it started
+	sim := simulation.NewSimulation(SolarGrav, 0, bodies...)
+	sim.Dt = 1.0 / 2000
+	sim.Steps(2000)
+
+	start, end := bodies[3], sim.Bodies[3]
+	moved := math.Sqrt(math.Pow(end.X-start.X, 2) + math.Pow(end.Y-start.Y, 2) + math.Pow(end.Z-start.Z, 2))
+	if moved > 0.01 {
+		t.Fatalf("expected the Earth to complete an orbit in a year, it is %v AU away", moved)
+	}
+}
+
+func TestPlanetary(t *testing.T) {
+	spec := Spec{
+		Model: ModelPlanetary,
+		Planetary: &Planetary{
+			Central: simulation.Body{Name: "star", X: 1, Radius: 1, Density: 1},
+			Orbits: []Orbiting{
+				{
+					Body:     simulation.Body{Name: "planet", Radius: 0.01, Density: 1},
+					Elements: Elements{SemiMajorAxis: 10, Eccentricity: 0.2, Inclination: 0.1, MeanAnomaly: 1},
+				},
+			},
+		},
+	}
+
+	bodies, err := Generate(spec, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bodies[0].X != 1 {
+		t.Fatalf("expected the central body to stay where it is, got %+v", bodies[0])
+	}
+	e := ElementsOf(bodies[1], bodies[0], 1)
+	if math.Abs(e.SemiMajorAxis-10) > 1e-9 || math.Abs(e.MeanAnomaly-1) > 1e-9 {
+		t.Fatalf("unexpected elements %+v", e)
+	}
+}
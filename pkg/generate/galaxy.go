@@ -0,0 +1,496 @@
+package generate
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+
+	"github.com/tardisman5197/barnes-hut-sim/pkg/simulation"
+)
+
+const (
+	// ModelGalaxy is a rotating disk galaxy, with N, Mass and
+	// ScaleRadius describing its exponential disk and Galaxy
+	// its bulge and halo.
+	ModelGalaxy = "galaxy"
+
+	// HaloNFW is a halo following the Navarro, Frenk and
+	// White profile, cut off at its Truncation, the
+	// concentration.
+	HaloNFW = "nfw"
+	// HaloHernquist is a halo following a Hernquist profile.
+	HaloHernquist = "hernquist"
+
+	// DefaultQ is the Toomre Q of a disk when one has not
+	// been chosen.
+	DefaultQ = 1.5
+	// DefaultScaleHeight is the scale height of a disk, as a
+	// fraction of its scale length, when one has not been
+	// chosen.
+	DefaultScaleHeight = 0.1
+	// DefaultConcentration is the radius, in scale radii, an
+	// NFW halo is cut off at when one has not been chosen.
+	DefaultConcentration = 10
+
+	// qRadius is the radius, in disk scale lengths, the disk
+	// has the Toomre Q it is given at.
+	qRadius = 2.5
+	// escapeFraction is the largest fraction of the escape
+	// speed bulge and halo bodies are given.
+	escapeFraction = 0.95
+	// jeansSamples is the number of radii the velocity
+	// dispersions of the bulge and halo are calculated at.
+	jeansSamples = 1024
+)
+
+// Galaxy describes the parts of a disk galaxy beyond its disk.
+type Galaxy struct {
+	// ScaleHeight is the scale height of the disk's sech²
+	// vertical profile, DefaultScaleHeight times its scale
+	// length when it is 0.
+	ScaleHeight float64 `json:"scaleHeight,omitempty"`
+	// Q is Toomre's stability parameter of the disk at
+	// 2.5 scale lengths, DefaultQ when it is 0. Disks with
+	// a Q below 1 are unstable to axisymmetric collapse.
+	Q float64 `json:"q,omitempty"`
+	// Bulge is a Hernquist bulge, none when it is nil.
+	Bulge *Component `json:"bulge,omitempty"`
+	// Halo is the dark matter halo, none when it is nil.
+	Halo *Halo `json:"halo,omitempty"`
+}
+
+// Component is a spherical part of a galaxy.
+type Component struct {
+	// N is the number of bodies and Mass their total mass.
+	N    int     `json:"n"`
+	Mass float64 `json:"mass"`
+	// ScaleRadius is the length scale of the profile.
+	ScaleRadius float64 `json:"scaleRadius"`
+	// Truncation is the radius, in scale radii, beyond
+	// which no bodies are placed.
+	Truncation float64 `json:"truncation,omitempty"`
+}
+
+// Halo is the dark matter halo of a galaxy. It is made of
+// bodies like the rest of the galaxy, so it responds to the
+// disk and bulge as the simulation runs.
+type Halo struct {
+	Component
+	// Profile is the name of the halo's profile, HaloNFW
+	// when it is empty.
+	Profile string `json:"profile,omitempty"`
+}
+
+// validate checks the component can be generated.
+func (c *Component) validate(name string) error {
+	if c.N <= 0 {
+		return fmt.Errorf("the number of %s bodies must be strictly positive", name)
+	}
+	if c.Mass <= 0 {
+		return fmt.Errorf("the mass of the %s must be strictly positive", name)
+	}
+	if c.ScaleRadius <= 0 {
+		return fmt.Errorf("the scale radius of the %s must be strictly positive", name)
+	}
+	if c.Truncation < 0 || (c.Truncation > 0 && c.Truncation < 1) {
+		return fmt.Errorf("the truncation of the %s must be at least 1 scale radius", name)
+	}
+	return nil
+}
+
+// validate checks the galaxy can be generated.
+func (g *Galaxy) validate() error {
+	if g.ScaleHeight < 0 {
+		return fmt.Errorf("the scale height of the disk must not be negative")
+	}
+	if g.Q < 0 {
+		return fmt.Errorf("the Toomre Q of the disk must not be negative")
+	}
+	if g.Bulge != nil {
+		if err := g.Bulge.validate("bulge"); err != nil {
+			return err
+		}
+	}
+	if g.Halo != nil {
+		if g.Halo.Profile != "" && g.Halo.Profile != HaloNFW && g.Halo.Profile != HaloHernquist {
+			return fmt.Errorf("unknown halo profile %q", g.Halo.Profile)
+		}
+		if err := g.Halo.validate("halo"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// galaxy returns the bodies of a disk galaxy, following
+// Hernquist (1993). The disk has an exponential surface
+// density and sech² vertical profile. Its bodies orbit at the
+// circular velocity of the whole galaxy, less the asymmetric
+// drift, with radial dispersions set by Toomre's Q and
+// vertical dispersions balancing the disk's own gravity. The
+// bulge and halo bodies have isotropic Gaussian velocities with
+// the dispersion found from the Jeans equation in the potential
+// of the whole galaxy, treating the disk as spherical.
+func galaxy(rnd *rand.Rand, spec Spec, grav float64) []simulation.Body {
+	g := newGalaxyModel(spec, grav)
+
+	bodies := g.disk(rnd)
+	for k, s := range g.spheres {
+		bodies = append(bodies, g.sphere(rnd, s, g.dispersion[k])...)
+	}
+	return bodies
+}
+
+// galaxyModel holds a disk galaxy while its bodies are
+// generated.
+type galaxyModel struct {
+	spec Spec
+	grav float64
+	// h and z0 are the scale length and height of the disk,
+	// rt the radius it is cut off at and sigma0 its central
+	// surface density
+	h, z0, rt, sigma0 float64
+	// q is the disk's Toomre Q
+	q float64
+	// spheres are the bulge and halo
+	spheres []sphere
+	// r holds radii spaced evenly in ln r between the center
+	// and the edge of the galaxy, and potential the potential
+	// at each, treating the disk as spherical
+	r, potential []float64
+	// dispersion holds the velocity dispersion squared of each
+	// sphere at each of r
+	dispersion [][]float64
+}
+
+// newGalaxyModel sets up the galaxy described by spec.
+func newGalaxyModel(spec Spec, grav float64) *galaxyModel {
+	parts := spec.Galaxy
+	if parts == nil {
+		parts = &Galaxy{}
+	}
+
+	g := &galaxyModel{
+		spec: spec,
+		grav: grav,
+		h:    spec.ScaleRadius,
+		z0:   parts.ScaleHeight,
+		rt:   spec.truncation(),
+		q:    parts.Q,
+	}
+	if g.z0 == 0 {
+		g.z0 = DefaultScaleHeight * g.h
+	}
+	if g.q == 0 {
+		g.q = DefaultQ
+	}
+
+	// The disk's bodies are its mass inside the truncation
+	x := g.rt / g.h
+	g.sigma0 = spec.Mass / (1 - (1+x)*math.Exp(-x)) / (2 * math.Pi * g.h * g.h)
+
+	if parts.Bulge != nil {
+		g.spheres = append(g.spheres, newSphere("bulge", HaloHernquist, *parts.Bulge))
+	}
+	if parts.Halo != nil {
+		profile := parts.Halo.Profile
+		if profile == "" {
+			profile = HaloNFW
+		}
+		g.spheres = append(g.spheres, newSphere("halo", profile, parts.Halo.Component))
+	}
+
+	g.jeans()
+	return g
+}
+
+// surface returns the surface density of the disk at a
+// radius R.
+func (g *galaxyModel) surface(R float64) float64 {
+	if R > g.rt {
+		return 0
+	}
+	return g.sigma0 * math.Exp(-R/g.h)
+}
+
+// enclosed returns the mass of the galaxy within a sphere of
+// radius r.
+func (g *galaxyModel) enclosed(r float64) float64 {
+	x := math.Min(r, g.rt) / g.h
+	m := 2 * math.Pi * g.sigma0 * g.h * g.h * (1 - (1+x)*math.Exp(-x))
+	for _, s := range g.spheres {
+		m += s.enclosed(r)
+	}
+	return m
+}
+
+// circular returns the circular velocity squared in the plane
+// of the disk at a radius R. The disk's part is that of an
+// infinitely thin exponential disk,
+//
+//	v² = 4πGΣ0 h y² (I0(y) K0(y) - I1(y) K1(y)),  y = R/2h
+func (g *galaxyModel) circular(R float64) float64 {
+	if R <= 0 {
+		return 0
+	}
+
+	y := R / (2 * g.h)
+	v2 := 4 * math.Pi * g.grav * g.sigma0 * g.h * y * y *
+		(i0e(y)*k0e(y) - i1e(y)*k1e(y))
+	for _, s := range g.spheres {
+		v2 += g.grav * s.enclosed(R) / R
+	}
+	return v2
+}
+
+// frequencies returns the angular frequency Ω² and epicyclic
+// frequency κ² = R dΩ²/dR + 4Ω² squared at a radius R.
+func (g *galaxyModel) frequencies(R float64) (omega2, kappa2 float64) {
+	omega := func(R float64) float64 {
+		return g.circular(R) / (R * R)
+	}
+
+	dR := 1e-3 * R
+	omega2 = omega(R)
+	kappa2 = R*(omega(R+dR)-omega(R-dR))/(2*dR) + 4*omega2
+	return omega2, math.Max(kappa2, 0)
+}
+
+// disk returns the bodies of the disk.
+func (g *galaxyModel) disk(rnd *rand.Rand) []simulation.Body {
+	// The radial dispersion falls as e^(-R/2h), scaled to
+	// give the disk its Q at qRadius scale lengths
+	ref := qRadius * g.h
+	_, kappa2 := g.frequencies(ref)
+	sigmaRef := g.q * 3.36 * g.grav * g.surface(ref) / math.Sqrt(kappa2)
+
+	bodies := make([]simulation.Body, g.spec.N)
+	for i := range bodies {
+		// The mass in each ring is proportional to R e^(-R/h),
+		// the sum of two exponential distributions
+		R := -g.h * math.Log(positive(rnd)*positive(rnd))
+		for R > g.rt {
+			R = -g.h * math.Log(positive(rnd)*positive(rnd))
+		}
+		phi := 2 * math.Pi * rnd.Float64()
+		z := g.z0 * math.Atanh(2*positive(rnd)-1)
+
+		omega2, kappa2 := g.frequencies(R)
+		sigmaR2 := sigmaRef * sigmaRef * math.Exp(-(R-ref)/g.h)
+		sigmaPhi2 := sigmaR2 * kappa2 / (4 * omega2)
+		sigmaZ2 := math.Pi * g.grav * g.surface(R) * g.z0
+
+		// The asymmetric drift slows the mean rotation
+		rotation := math.Sqrt(math.Max(g.circular(R)+sigmaR2*(1-kappa2/(4*omega2)-2*R/g.h), 0))
+
+		vR := math.Sqrt(sigmaR2) * rnd.NormFloat64()
+		vPhi := rotation + math.Sqrt(sigmaPhi2)*rnd.NormFloat64()
+		vZ := math.Sqrt(sigmaZ2) * rnd.NormFloat64()
+
+		cos, sin := math.Cos(phi), math.Sin(phi)
+		bodies[i] = g.spec.particle(g.name("disk", i), g.spec.Mass/float64(g.spec.N),
+			[3]float64{R * cos, R * sin, z},
+			[3]float64{vR*cos - vPhi*sin, vR*sin + vPhi*cos, vZ})
+	}
+	return bodies
+}
+
+// sphere returns the bodies of the bulge or halo s, with
+// dispersion its velocity dispersion squared at each of g.r.
+func (g *galaxyModel) sphere(rnd *rand.Rand, s sphere, dispersion []float64) []simulation.Body {
+	bodies := make([]simulation.Body, s.n)
+	for i := range bodies {
+		r := s.radius(rnd)
+		sigma := math.Sqrt(g.interpolate(dispersion, r))
+		escape := math.Sqrt(-2 * g.interpolate(g.potential, r))
+
+		var v [3]float64
+		for {
+			v = [3]float64{sigma * rnd.NormFloat64(), sigma * rnd.NormFloat64(), sigma * rnd.NormFloat64()}
+			if math.Sqrt(v[0]*v[0]+v[1]*v[1]+v[2]*v[2]) < escapeFraction*escape {
+				break
+			}
+		}
+
+		x, y, z := direction(rnd)
+		bodies[i] = g.spec.particle(g.name(s.name, i), s.mass/float64(s.n), [3]float64{r * x, r * y, r * z}, v)
+	}
+	return bodies
+}
+
+// name returns the name of the i-th body of a part of the
+// galaxy.
+func (g *galaxyModel) name(part string, i int) string {
+	if g.spec.Name != "" {
+		return fmt.Sprintf("%s-%s-%d", g.spec.Name, part, i)
+	}
+	return fmt.Sprintf("%s-%d", part, i)
+}
+
+// jeans calculates the potential of the galaxy and the
+// velocity dispersion of each sphere from the Jeans equation
+// for an isotropic system,
+//
+//	σ²(r) = 1/ρ(r) ∫ ρ(r') G M(r') / r'² dr'
+//
+// integrated from r out to the edge of the sphere.
+func (g *galaxyModel) jeans() {
+	outer, inner := g.rt, g.h
+	for _, s := range g.spheres {
+		outer = math.Max(outer, s.rt)
+		inner = math.Min(inner, s.a)
+	}
+	inner *= 1e-4
+
+	g.r = make([]float64, jeansSamples)
+	force := make([]float64, jeansSamples)
+	step := math.Log(outer/inner) / (jeansSamples - 1)
+	for i := range g.r {
+		g.r[i] = inner * math.Exp(float64(i)*step)
+		force[i] = g.grav * g.enclosed(g.r[i]) / (g.r[i] * g.r[i])
+	}
+
+	// Integrate the force in from the edge, where the
+	// potential is that of a point mass, using dr = r dln r
+	g.potential = make([]float64, jeansSamples)
+	last := jeansSamples - 1
+	g.potential[last] = -g.grav * g.enclosed(outer) / outer
+	for i := last - 1; i >= 0; i-- {
+		g.potential[i] = g.potential[i+1] - step*(force[i]*g.r[i]+force[i+1]*g.r[i+1])/2
+	}
+
+	g.dispersion = make([][]float64, len(g.spheres))
+	for k, s := range g.spheres {
+		dispersion := make([]float64, jeansSamples)
+		var integral float64
+		for i := last - 1; i >= 0; i-- {
+			integral += step * (s.density(g.r[i])*force[i]*g.r[i] + s.density(g.r[i+1])*force[i+1]*g.r[i+1]) / 2
+			if rho := s.density(g.r[i]); rho > 0 {
+				dispersion[i] = integral / rho
+			}
+		}
+		g.dispersion[k] = dispersion
+	}
+}
+
+// interpolate returns the value of table, which holds a value
+// for each of g.r, at the radius r.
+func (g *galaxyModel) interpolate(table []float64, r float64) float64 {
+	step := math.Log(g.r[1] / g.r[0])
+	f := math.Log(r/g.r[0]) / step
+	if f <= 0 {
+		return table[0]
+	}
+
+	i := int(f)
+	if i >= len(table)-1 {
+		return table[len(table)-1]
+	}
+	f -= float64(i)
+	return table[i] + f*(table[i+1]-table[i])
+}
+
+// sphere is a truncated spherical part of a galaxy.
+type sphere struct {
+	name    string
+	profile string
+	// n is the number of bodies and mass their total mass,
+	// the mass within the truncation radius rt
+	n    int
+	mass float64
+	// a is the scale radius
+	a, rt float64
+	// total is the mass of the untruncated profile, or for
+	// an NFW profile m(rt/a)
+	total float64
+}
+
+// newSphere returns a sphere of the component c with the
+// named profile.
+func newSphere(name, profile string, c Component) sphere {
+	s := sphere{
+		name:    name,
+		profile: profile,
+		n:       c.N,
+		mass:    c.Mass,
+		a:       c.ScaleRadius,
+		rt:      c.Truncation * c.ScaleRadius,
+	}
+
+	switch profile {
+	case HaloNFW:
+		if s.rt == 0 {
+			s.rt = DefaultConcentration * s.a
+		}
+		s.total = nfwMass(s.rt / s.a)
+	default:
+		if s.rt == 0 {
+			s.rt = DefaultTruncation * s.a
+		}
+		s.total = s.mass * (s.rt + s.a) * (s.rt + s.a) / (s.rt * s.rt)
+	}
+	return s
+}
+
+// enclosed returns the mass of the sphere within r.
+func (s sphere) enclosed(r float64) float64 {
+	if r >= s.rt {
+		return s.mass
+	}
+
+	if s.profile == HaloNFW {
+		return s.mass * nfwMass(r/s.a) / s.total
+	}
+	return s.total * r * r / ((r + s.a) * (r + s.a))
+}
+
+// density returns the density of the sphere at r.
+func (s sphere) density(r float64) float64 {
+	if r >= s.rt {
+		return 0
+	}
+
+	x := r / s.a
+	if s.profile == HaloNFW {
+		return s.mass / (4 * math.Pi * s.a * s.a * s.a * s.total) / (x * (1 + x) * (1 + x))
+	}
+	return s.total / (2 * math.Pi * s.a * s.a * s.a) / (x * (1 + x) * (1 + x) * (1 + x))
+}
+
+// radius draws the distance of a body of the sphere from
+// its center, by inverting the enclosed mass.
+func (s sphere) radius(rnd *rand.Rand) float64 {
+	m := rnd.Float64() * s.mass
+	if s.profile != HaloNFW {
+		q := math.Sqrt(m / s.total)
+		return s.a * q / (1 - q)
+	}
+
+	// The NFW mass cannot be inverted in closed form, so
+	// bisect it
+	lo, hi := 0.0, s.rt
+	for i := 0; i < 64; i++ {
+		mid := (lo + hi) / 2
+		if s.enclosed(mid) < m {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return (lo + hi) / 2
+}
+
+// nfwMass returns the mass of an NFW profile within x scale
+// radii, in units of 4πρ0a³.
+func nfwMass(x float64) float64 {
+	return math.Log(1+x) - x/(1+x)
+}
+
+// positive returns a random number in (0, 1).
+func positive(rnd *rand.Rand) float64 {
+	for {
+		if u := rnd.Float64(); u > 0 {
+			return u
+		}
+	}
+}
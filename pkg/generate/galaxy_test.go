@@ -0,0 +1,139 @@
+package generate
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/tardisman5197/barnes-hut-sim/pkg/simulation"
+)
+
+func TestBessel(t *testing.T) {
+	// Values from table 9.8 of Abramowitz and Stegun
+	tests := []struct {
+		x, i0, i1, k0, k1 float64
+	}{
+		{0.5, 1.0634834, 0.2578943, 0.9244191, 1.6564411},
+		{1, 1.2660659, 0.5651591, 0.4210244, 0.6019072},
+		{5, 27.239872, 24.335642, 0.0036911, 0.0040446},
+	}
+
+	for _, test := range tests {
+		got := []float64{
+			i0e(test.x) * math.Exp(test.x),
+			i1e(test.x) * math.Exp(test.x),
+			k0e(test.x) * math.Exp(-test.x),
+			k1e(test.x) * math.Exp(-test.x),
+		}
+		want := []float64{test.i0, test.i1, test.k0, test.k1}
+		for i := range got {
+			if math.Abs(got[i]-want[i]) > 1e-6*math.Max(1, want[i]) {
+				t.Fatalf("x %v: expected %v, got %v", test.x, want, got)
+			}
+		}
+	}
+}
+
+func TestGalaxy(t *testing.T) {
+	spec := Spec{
+		Model:       ModelGalaxy,
+		N:           2000,
+		Mass:        1,
+		ScaleRadius: 1,
+		Seed:        5,
+		Galaxy: &Galaxy{
+			Bulge: &Component{N: 500, Mass: 0.3, ScaleRadius: 0.2},
+			Halo:  &Halo{Component: Component{N: 2000, Mass: 5, ScaleRadius: 3}},
+		},
+	}
+
+	bodies, err := Generate(spec, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counts := map[string]int{}
+	masses := map[string]float64{}
+	for _, b := range bodies {
+		part := b.Name[:strings.Index(b.Name, "-")]
+		counts[part]++
+		masses[part] += b.Mass()
+	}
+
+	expected := map[string]struct {
+		n    int
+		mass float64
+	}{
+		"disk":  {2000, 1},
+		"bulge": {500, 0.3},
+		"halo":  {2000, 5},
+	}
+	for part, e := range expected {
+		if counts[part] != e.n || math.Abs(masses[part]-e.mass) > 1e-9 {
+			t.Fatalf("expected %d %s bodies of mass %v, got %d of mass %v", e.n, part, e.mass, counts[part], masses[part])
+		}
+	}
+
+	sim := simulation.NewSimulation(1, 0.3, bodies...)
+	d := sim.Diagnose()
+
+	// The disk rotates about the z axis
+	if d.AngularMomentum.Z <= 0 || math.Abs(d.AngularMomentum.X) > 0.2*d.AngularMomentum.Z {
+		t.Fatalf("expected the galaxy to rotate about the z axis, got %+v", d.AngularMomentum)
+	}
+	if math.Abs(d.Virial-1) > 0.15 {
+		t.Fatalf("expected a virial ratio close to 1, got %v", d.Virial)
+	}
+}
+
+func TestGalaxyToomreQ(t *testing.T) {
+	for _, q := range []float64{1, 2} {
+		spec := Spec{
+			Model:       ModelGalaxy,
+			N:           20000,
+			Mass:        1,
+			ScaleRadius: 1,
+			Seed:        6,
+			Galaxy:      &Galaxy{Q: q},
+		}
+
+		bodies, err := Generate(spec, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Measure the radial dispersion of the disk in a ring
+		// around 2.5 scale lengths
+		var sum, count float64
+		for _, b := range bodies {
+			R := math.Hypot(b.X, b.Y)
+			if !strings.HasPrefix(b.Name, "disk-") || R < 2.25 || R > 2.75 {
+				continue
+			}
+			vR := (b.X*b.VX + b.Y*b.VY) / R
+			sum += vR * vR
+			count++
+		}
+
+		g := newGalaxyModel(spec, 1)
+		_, kappa2 := g.frequencies(2.5)
+		measured := math.Sqrt(sum/count) * math.Sqrt(kappa2) / (3.36 * g.surface(2.5))
+		if math.Abs(measured-q)/q > 0.15 {
+			t.Fatalf("expected a Toomre Q of %v, measured %v", q, measured)
+		}
+	}
+}
+
+func TestGalaxyInvalid(t *testing.T) {
+	specs := []Spec{
+		{Model: ModelGalaxy, N: 10, Mass: 1, ScaleRadius: 1, Galaxy: &Galaxy{Q: -1}},
+		{Model: ModelGalaxy, N: 10, Mass: 1, ScaleRadius: 1, Galaxy: &Galaxy{Bulge: &Component{N: 0, Mass: 1, ScaleRadius: 1}}},
+		{Model: ModelGalaxy, N: 10, Mass: 1, ScaleRadius: 1, Galaxy: &Galaxy{Halo: &Halo{Component: Component{N: 10, Mass: 1, ScaleRadius: 1}, Profile: "isothermal"}}},
+	}
+
+	for _, spec := range specs {
+		if _, err := Generate(spec, 1); err == nil {
+			t.Fatalf("expected an error for %+v", spec)
+		}
+	}
+}
@@ -0,0 +1,313 @@
+// Package generate creates the bodies of star clusters and
+// galaxies in equilibrium, to start simulations from.
+//
+// Each model is set up by a Spec, in the units of the
+// gravitational constant the simulation uses. The same Spec
+// and Seed always give the same bodies.
+package generate
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+
+	"github.com/tardisman5197/barnes-hut-sim/pkg/simulation"
+)
+
+const (
+	// ModelPlummer is a Plummer sphere, with ScaleRadius
+	// the Plummer radius.
+	ModelPlummer = "plummer"
+	// ModelHernquist is a Hernquist profile, with
+	// ScaleRadius its scale length. It is a good match for
+	// elliptical galaxies and bulges.
+	ModelHernquist = "hernquist"
+	// ModelKing is a King model with a central potential
+	// of W0, with ScaleRadius the King radius. It ends at
+	// its tidal radius and is a good match for globular
+	// clusters.
+	ModelKing = "king"
+
+	// DefaultTruncation is the radius, in scale radii, the
+	// Plummer and Hernquist models, and the disk and bulge
+	// of a galaxy, are cut off at when one has not been
+	// chosen.
+	DefaultTruncation = 100
+	// DefaultBodyRadius is the radius of each body, as a
+	// fraction of the scale radius, when one has not been
+	// chosen.
+	DefaultBodyRadius = 0.001
+)
+
+// Spec describes a model to generate bodies from.
+type Spec struct {
+	// Model is the name of the model, such as ModelPlummer.
+	Model string `json:"model"`
+	// N is the number of bodies.
+	N int `json:"n"`
+	// Mass is the total mass of the bodies, which each
+	// have an equal share of.
+	Mass float64 `json:"mass"`
+	// ScaleRadius is the length scale of the model.
+	ScaleRadius float64 `json:"scaleRadius"`
+	// W0 is the dimensionless central potential of a King
+	// model, larger values being more concentrated. Typical
+	// globular clusters are between 3 and 9.
+	W0 float64 `json:"w0,omitempty"`
+	// Truncation is the radius, in scale radii, beyond
+	// which no bodies are placed in the Plummer, Hernquist
+	// and galaxy models, DefaultTruncation when it is 0.
+	Truncation float64 `json:"truncation,omitempty"`
+	// BodyRadius is the radius of each body, the scale
+	// radius times DefaultBodyRadius when it is 0. Their
+	// density is chosen to give them their mass.
+	BodyRadius float64 `json:"bodyRadius,omitempty"`
+	// Name starts the name of each body, which is followed
+	// by its index. The name of the model is used when it
+	// is empty.
+	Name string `json:"name,omitempty"`
+	// Seed seeds the random numbers the bodies are drawn
+	// with.
+	Seed int64 `json:"seed,omitempty"`
+	// Galaxy describes the bulge and halo of a ModelGalaxy,
+	// along with the shape of its disk.
+	Galaxy *Galaxy `json:"galaxy,omitempty"`
+	// Planetary describes the bodies of a ModelPlanetary.
+	// Neither it nor ModelSolar use the other fields.
+	Planetary *Planetary `json:"planetary,omitempty"`
+}
+
+// Validate checks the Spec describes a model that can be
+// generated.
+func (s Spec) Validate() error {
+	switch s.Model {
+	case ModelSolar:
+		return nil
+	case ModelPlanetary:
+		if s.Planetary == nil {
+			return fmt.Errorf("a planetary model needs its bodies")
+		}
+		return s.Planetary.validate()
+	case ModelPlummer, ModelHernquist:
+	case ModelGalaxy:
+		if s.Galaxy != nil {
+			if err := s.Galaxy.validate(); err != nil {
+				return err
+			}
+		}
+	case ModelKing:
+		if s.W0 <= 0 || s.W0 > maxW0 {
+			return fmt.Errorf("the central potential W0 of a king model must be between 0 and %v", maxW0)
+		}
+	default:
+		return fmt.Errorf("unknown model %q", s.Model)
+	}
+
+	if s.N <= 0 {
+		return fmt.Errorf("the number of bodies must be strictly positive")
+	}
+	if s.Mass <= 0 {
+		return fmt.Errorf("the mass must be strictly positive")
+	}
+	if s.ScaleRadius <= 0 {
+		return fmt.Errorf("the scale radius must be strictly positive")
+	}
+	if s.Truncation < 0 || (s.Truncation > 0 && s.Truncation < 1) {
+		return fmt.Errorf("the truncation must be at least 1 scale radius")
+	}
+	if s.BodyRadius < 0 {
+		return fmt.Errorf("the body radius must not be negative")
+	}
+	return nil
+}
+
+// Count returns the number of bodies the model generates, so
+// it can be checked before they are generated. It is the largest
+// int when there are more than that.
+func (s Spec) Count() int {
+	switch s.Model {
+	case ModelSolar:
+		return 1 + len(planets)
+	case ModelPlanetary:
+		if s.Planetary == nil {
+			return 0
+		}
+		return 1 + len(s.Planetary.Orbits)
+	case ModelGalaxy:
+		if s.Galaxy == nil {
+			return s.N
+		}
+		n := s.N
+		if s.Galaxy.Bulge != nil {
+			n = sum(n, s.Galaxy.Bulge.N)
+		}
+		if s.Galaxy.Halo != nil {
+			n = sum(n, s.Galaxy.Halo.N)
+		}
+		return n
+	}
+	return s.N
+}
+
+// sum returns a + b, or the largest int when that is more
+// than an int can hold. Negative counts are not added.
+func sum(a, b int) int {
+	const largest = int(^uint(0) >> 1)
+	if b <= 0 {
+		return a
+	}
+	if a > largest-b {
+		return largest
+	}
+	return a + b
+}
+
+// Generate returns the bodies of the model described by spec,
+// with grav the gravitational constant of the simulation they
+// are for. The bodies are moved so their center of mass is at
+// rest at the origin, except for a ModelPlanetary which keeps
+// its central body where it is.
+func Generate(spec Spec, grav float64) ([]simulation.Body, error) {
+	if err := spec.Validate(); err != nil {
+		return nil, err
+	}
+	if grav <= 0 {
+		return nil, fmt.Errorf("the gravitational constant must be strictly positive")
+	}
+
+	rnd := rand.New(rand.NewSource(spec.Seed))
+
+	var bodies []simulation.Body
+	switch spec.Model {
+	case ModelPlummer:
+		bodies = plummer(rnd, spec, grav)
+	case ModelHernquist:
+		bodies = hernquist(rnd, spec, grav)
+	case ModelKing:
+		bodies = king(rnd, spec, grav)
+	case ModelGalaxy:
+		bodies = galaxy(rnd, spec, grav)
+	case ModelSolar:
+		return SolarSystem(grav), nil
+	case ModelPlanetary:
+		return spec.Planetary.bodies(grav), nil
+	}
+
+	recenter(bodies)
+	return bodies, nil
+}
+
+// truncation returns the radius the model is cut off at.
+func (s Spec) truncation() float64 {
+	if s.Truncation > 0 {
+		return s.Truncation * s.ScaleRadius
+	}
+	return DefaultTruncation * s.ScaleRadius
+}
+
+// body returns the i-th body of the model, at a distance r
+// from the center moving at speed v, both in random
+// directions.
+func (s Spec) body(rnd *rand.Rand, i int, r, v float64) simulation.Body {
+	name := s.Name
+	if name == "" {
+		name = s.Model
+	}
+
+	x, y, z := direction(rnd)
+	vx, vy, vz := direction(rnd)
+	return s.particle(fmt.Sprintf("%s-%d", name, i), s.Mass/float64(s.N),
+		[3]float64{r * x, r * y, r * z}, [3]float64{v * vx, v * vy, v * vz})
+}
+
+// particle returns a body of the model with the given name,
+// mass, position and velocity.
+func (s Spec) particle(name string, mass float64, pos, vel [3]float64) simulation.Body {
+	radius := s.BodyRadius
+	if radius == 0 {
+		radius = DefaultBodyRadius * s.ScaleRadius
+	}
+
+	return simulation.Body{
+		Name:    name,
+		X:       pos[0],
+		Y:       pos[1],
+		Z:       pos[2],
+		VX:      vel[0],
+		VY:      vel[1],
+		VZ:      vel[2],
+		Radius:  radius,
+		Density: density(mass, radius),
+	}
+}
+
+// density returns the density a body of the given radius
+// needs to have the given mass.
+func density(mass, radius float64) float64 {
+	return mass / ((4.0 / 3.0) * math.Pi * math.Pow(radius, 3))
+}
+
+// direction returns a random unit vector, every direction
+// being equally likely.
+func direction(rnd *rand.Rand) (x, y, z float64) {
+	z = 2*rnd.Float64() - 1
+	phi := 2 * math.Pi * rnd.Float64()
+	s := math.Sqrt(1 - z*z)
+	return s * math.Cos(phi), s * math.Sin(phi), z
+}
+
+// speedSamples is the number of speeds the peak of a speed
+// distribution is looked for at.
+const speedSamples = 256
+
+// speed draws a speed between 0 and max from the distribution
+// with a density proportional to p, by rejection sampling.
+func speed(rnd *rand.Rand, max float64, p func(v float64) float64) float64 {
+	// The samples are packed towards 0, where the peak is
+	// narrowest near the center of concentrated models
+	var peak float64
+	for i := 1; i <= speedSamples; i++ {
+		f := float64(i) / speedSamples
+		if q := p(max * f * f); q > peak {
+			peak = q
+		}
+	}
+	peak *= 1.2
+
+	for {
+		v := max * rnd.Float64()
+		if rnd.Float64()*peak <= p(v) {
+			return v
+		}
+	}
+}
+
+// recenter moves the bodies so their center of mass is at
+// rest at the origin.
+func recenter(bodies []simulation.Body) {
+	var c simulation.Body
+	var total float64
+	for _, b := range bodies {
+		m := b.Mass()
+		total += m
+		c.X += m * b.X
+		c.Y += m * b.Y
+		c.Z += m * b.Z
+		c.VX += m * b.VX
+		c.VY += m * b.VY
+		c.VZ += m * b.VZ
+	}
+	if total == 0 {
+		return
+	}
+
+	for i := range bodies {
+		b := &bodies[i]
+		b.X -= c.X / total
+		b.Y -= c.Y / total
+		b.Z -= c.Z / total
+		b.VX -= c.VX / total
+		b.VY -= c.VY / total
+		b.VZ -= c.VZ / total
+	}
+}
@@ -0,0 +1,138 @@
+package generate
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/tardisman5197/barnes-hut-sim/pkg/simulation"
+)
+
+func TestGenerateEquilibrium(t *testing.T) {
+	specs := []Spec{
+		{Model: ModelPlummer, N: 2000, Mass: 10, ScaleRadius: 2, Seed: 1},
+		{Model: ModelHernquist, N: 2000, Mass: 10, ScaleRadius: 2, Seed: 2},
+		{Model: ModelKing, N: 2000, Mass: 10, ScaleRadius: 2, W0: 6, Seed: 3},
+	}
+
+	for _, spec := range specs {
+		bodies, err := Generate(spec, 0.5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(bodies) != spec.N {
+			t.Fatalf("%s: expected %d bodies, got %d", spec.Model, spec.N, len(bodies))
+		}
+
+		var mass float64
+		for _, b := range bodies {
+			mass += (4.0 / 3.0) * math.Pi * math.Pow(b.Radius, 3) * b.Density
+		}
+		if math.Abs(mass-spec.Mass) > 1e-9*spec.Mass {
+			t.Fatalf("%s: expected a mass of %v, got %v", spec.Model, spec.Mass, mass)
+		}
+
+		sim := simulation.NewSimulation(0.5, 0, bodies...)
+		d := sim.Diagnose()
+
+		if d.CenterOfMass.Length() > 1e-9 || d.Momentum.Length() > 1e-9 {
+			t.Fatalf("%s: expected the center of mass at rest at the origin, got %+v and %+v", spec.Model, d.CenterOfMass, d.Momentum)
+		}
+
+		// A model in equilibrium has a virial ratio of 1,
+		// up to the noise of drawing a finite number of bodies
+		if math.Abs(d.Virial-1) > 0.1 {
+			t.Fatalf("%s: expected a virial ratio close to 1, got %v", spec.Model, d.Virial)
+		}
+	}
+}
+
+func TestGenerateSeed(t *testing.T) {
+	spec := Spec{Model: ModelPlummer, N: 100, Mass: 1, ScaleRadius: 1, Seed: 42}
+
+	a, _ := Generate(spec, 1)
+	b, _ := Generate(spec, 1)
+	if !reflect.DeepEqual(a, b) {
+		t.Fatal("expected the same seed to give the same bodies")
+	}
+
+	spec.Seed++
+	c, _ := Generate(spec, 1)
+	if reflect.DeepEqual(a, c) {
+		t.Fatal("expected a different seed to give different bodies")
+	}
+
+	if a[0].Name != "plummer-0" {
+		t.Fatalf("expected the first body to be named plummer-0, got %s", a[0].Name)
+	}
+}
+
+func TestKingTidalRadius(t *testing.T) {
+	// The concentration log10(rt/r0) of King models, from
+	// table 4.1 of Binney and Tremaine
+	concentrations := map[float64]float64{
+		3: 0.67,
+		6: 1.25,
+		9: 2.12,
+	}
+
+	for w0, c := range concentrations {
+		p := solveKing(w0)
+		rt := p.r[len(p.r)-1]
+		if math.Abs(math.Log10(rt)-c) > 0.02 {
+			t.Fatalf("W0 %v: expected a concentration of %v, got %v", w0, c, math.Log10(rt))
+		}
+	}
+}
+
+func TestGenerateInvalid(t *testing.T) {
+	specs := []Spec{
+		{Model: "unknown", N: 10, Mass: 1, ScaleRadius: 1},
+		{Model: ModelPlummer, N: 0, Mass: 1, ScaleRadius: 1},
+		{Model: ModelPlummer, N: 10, Mass: -1, ScaleRadius: 1},
+		{Model: ModelHernquist, N: 10, Mass: 1, ScaleRadius: 0},
+		{Model: ModelKing, N: 10, Mass: 1, ScaleRadius: 1},
+	}
+
+	for _, spec := range specs {
+		if _, err := Generate(spec, 1); err == nil {
+			t.Fatalf("expected an error for %+v", spec)
+		}
+	}
+}
+
+func TestGenerateCount(t *testing.T) {
+	galaxy := Spec{
+		Model: ModelGalaxy, N: 200, Mass: 1, ScaleRadius: 1, Seed: 3,
+		Galaxy: &Galaxy{
+			Bulge: &Component{N: 50, Mass: 0.3, ScaleRadius: 0.2},
+			Halo:  &Halo{Component: Component{N: 100, Mass: 5, ScaleRadius: 5}},
+		},
+	}
+	planetary := Spec{Model: ModelPlanetary, Planetary: &Planetary{
+		Central: simulation.Body{Name: "star", Radius: 1, Density: 1},
+		Orbits:  []Orbiting{{Body: simulation.Body{Name: "planet", Radius: 0.01, Density: 1}, Elements: Elements{SemiMajorAxis: 10}}},
+	}}
+
+	for _, spec := range []Spec{
+		{Model: ModelPlummer, N: 100, Mass: 1, ScaleRadius: 1},
+		galaxy,
+		planetary,
+		{Model: ModelSolar},
+	} {
+		bodies, err := Generate(spec, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if spec.Count() != len(bodies) {
+			t.Fatalf("expected a %s model to count %d bodies, got %d", spec.Model, len(bodies), spec.Count())
+		}
+	}
+
+	// A count too large for an int is not wrapped around
+	huge := galaxy
+	huge.N = int(^uint(0) >> 1)
+	if n := huge.Count(); n != huge.N {
+		t.Fatalf("expected the count to stop at %d, got %d", huge.N, n)
+	}
+}
@@ -0,0 +1,49 @@
+package generate
+
+import (
+	"math"
+	"math/rand"
+
+	"github.com/tardisman5197/barnes-hut-sim/pkg/simulation"
+)
+
+// hernquist returns the bodies of a Hernquist (1990) profile.
+// The radii come from inverting the enclosed mass,
+// M r² / (r + a)², and the speeds from its isotropic
+// distribution function.
+func hernquist(rnd *rand.Rand, spec Spec, grav float64) []simulation.Body {
+	a := spec.ScaleRadius
+	rt := spec.truncation()
+	limit := (rt / (rt + a)) * (rt / (rt + a))
+
+	// vg² is the scale of the binding energy
+	vg2 := grav * spec.Mass / a
+
+	bodies := make([]simulation.Body, spec.N)
+	for i := range bodies {
+		q := math.Sqrt(limit * rnd.Float64())
+		r := a * q / (1 - q)
+
+		psi := grav * spec.Mass / (r + a)
+		v := speed(rnd, math.Sqrt(2*psi), func(v float64) float64 {
+			return v * v * hernquistDF((psi-v*v/2)/vg2)
+		})
+
+		bodies[i] = spec.body(rnd, i, r, v)
+	}
+	return bodies
+}
+
+// hernquistDF returns the distribution function of a
+// Hernquist profile, up to a constant, for a binding energy e
+// in units of GM/a.
+func hernquistDF(e float64) float64 {
+	if e <= 0 || e >= 1 {
+		return 0
+	}
+
+	q := math.Sqrt(e)
+	q2 := q * q
+	return (3*math.Asin(q) + q*math.Sqrt(1-q2)*(1-2*q2)*(8*q2*q2-8*q2-3)) /
+		math.Pow(1-q2, 2.5)
+}
@@ -0,0 +1,134 @@
+package generate
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+
+	"github.com/tardisman5197/barnes-hut-sim/pkg/simulation"
+)
+
+const (
+	// maxW0 is the most concentrated King model that can
+	// be generated.
+	maxW0 = 20
+	// kingStep is the step in ln r the King model is
+	// integrated with.
+	kingStep = 0.005
+)
+
+// king returns the bodies of a King (1966) model. The model's
+// potential is found by integrating Poisson's equation out
+// from the center until it reaches the tidal radius. The radii
+// come from inverting the enclosed mass and the speeds from
+// the lowered isothermal distribution function,
+// proportional to e^(E/σ²) - 1 for a binding energy E.
+func king(rnd *rand.Rand, spec Spec, grav float64) []simulation.Body {
+	profile := solveKing(spec.W0)
+	total := profile.m[len(profile.m)-1]
+
+	// The profile is in units of the King radius r0 and the
+	// central density ρ0, which set the velocity dispersion
+	// σ² = 4πGρ0r0²/9
+	r0 := spec.ScaleRadius
+	rho0 := spec.Mass / (total * r0 * r0 * r0)
+	sigma := math.Sqrt(4 * math.Pi * grav * rho0 * r0 * r0 / 9)
+
+	bodies := make([]simulation.Body, spec.N)
+	for i := range bodies {
+		r, w := profile.at(total * rnd.Float64())
+
+		v := speed(rnd, math.Sqrt(2*w), func(v float64) float64 {
+			e := w - v*v/2
+			if e <= 0 {
+				return 0
+			}
+			return v * v * (math.Exp(e) - 1)
+		})
+
+		bodies[i] = spec.body(rnd, i, r*r0, v*sigma)
+	}
+	return bodies
+}
+
+// kingProfile is a King model in units of the King radius and
+// the central density. It holds the dimensionless potential w
+// and enclosed mass m at increasing radii r, out to the tidal
+// radius where w is 0.
+type kingProfile struct {
+	r, w, m []float64
+}
+
+// solveKing integrates the King model with a central potential
+// of w0. With W = Ψ/σ² Poisson's equation becomes
+//
+//	dW/dr = -9 m / (4π r²),  dm/dr = 4π r² ρ(W)
+//
+// which is integrated in ln r with a fourth order Runge-Kutta
+// method, starting from the series W = w0 - 3r²/2 close to the
+// center.
+func solveKing(w0 float64) kingProfile {
+	rho := func(w float64) float64 {
+		return kingDensity(w) / kingDensity(w0)
+	}
+	deriv := func(x, w, m float64) (float64, float64) {
+		r := math.Exp(x)
+		return -9 * m / (4 * math.Pi * r), 4 * math.Pi * r * r * r * rho(w)
+	}
+
+	r := 1e-4
+	p := kingProfile{
+		r: []float64{0, r},
+		w: []float64{w0, w0 - 1.5*r*r},
+		m: []float64{0, 4 * math.Pi * r * r * r / 3},
+	}
+
+	x, w, m := math.Log(r), p.w[1], p.m[1]
+	h := kingStep
+	for {
+		dw1, dm1 := deriv(x, w, m)
+		dw2, dm2 := deriv(x+h/2, w+h/2*dw1, m+h/2*dm1)
+		dw3, dm3 := deriv(x+h/2, w+h/2*dw2, m+h/2*dm2)
+		dw4, dm4 := deriv(x+h, w+h*dw3, m+h*dm3)
+		nw := w + h/6*(dw1+2*dw2+2*dw3+dw4)
+		nm := m + h/6*(dm1+2*dm2+2*dm3+dm4)
+
+		if nw <= 0 {
+			// End at the tidal radius, where W crosses 0
+			f := w / (w - nw)
+			p.r = append(p.r, math.Exp(x+f*h))
+			p.w = append(p.w, 0)
+			p.m = append(p.m, m+f*(nm-m))
+			return p
+		}
+
+		x, w, m = x+h, nw, nm
+		p.r = append(p.r, math.Exp(x))
+		p.w = append(p.w, w)
+		p.m = append(p.m, m)
+	}
+}
+
+// at returns the radius enclosing the mass m and the
+// potential there, interpolating between the integrated radii.
+func (p kingProfile) at(m float64) (r, w float64) {
+	i := sort.SearchFloat64s(p.m, m)
+	if i == 0 {
+		return p.r[0], p.w[0]
+	}
+	if i == len(p.m) {
+		i--
+	}
+
+	f := (m - p.m[i-1]) / (p.m[i] - p.m[i-1])
+	return p.r[i-1] + f*(p.r[i]-p.r[i-1]), p.w[i-1] + f*(p.w[i]-p.w[i-1])
+}
+
+// kingDensity returns the density of a King model, up to a
+// constant, where its dimensionless potential is w.
+func kingDensity(w float64) float64 {
+	if w <= 0 {
+		return 0
+	}
+	return math.Exp(w)*math.Erf(math.Sqrt(w)) - math.Sqrt(4*w/math.Pi)*(1+2*w/3)
+}
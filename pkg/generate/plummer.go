@@ -0,0 +1,40 @@
+package generate
+
+import (
+	"math"
+	"math/rand"
+
+	"github.com/tardisman5197/barnes-hut-sim/pkg/simulation"
+)
+
+// plummer returns the bodies of a Plummer sphere, following
+// Aarseth, Hénon and Wielen (1974). The radii come from
+// inverting the enclosed mass, M r³ / (r² + a²)^(3/2), and the
+// speeds from the distribution function, proportional to
+// E^(7/2) for a binding energy E.
+func plummer(rnd *rand.Rand, spec Spec, grav float64) []simulation.Body {
+	a := spec.ScaleRadius
+	rt := spec.truncation()
+	limit := math.Pow(rt*rt/(rt*rt+a*a), 1.5)
+
+	bodies := make([]simulation.Body, spec.N)
+	for i := range bodies {
+		m := limit * rnd.Float64()
+		for m == 0 {
+			m = limit * rnd.Float64()
+		}
+		r := a / math.Sqrt(math.Pow(m, -2.0/3.0)-1)
+
+		psi := grav * spec.Mass / math.Sqrt(r*r+a*a)
+		v := speed(rnd, math.Sqrt(2*psi), func(v float64) float64 {
+			e := psi - v*v/2
+			if e <= 0 {
+				return 0
+			}
+			return v * v * math.Pow(e, 3.5)
+		})
+
+		bodies[i] = spec.body(rnd, i, r, v)
+	}
+	return bodies
+}
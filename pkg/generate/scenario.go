@@ -0,0 +1,267 @@
+package generate
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/tardisman5197/barnes-hut-sim/pkg/simulation"
+)
+
+const (
+	// OrbitImpact starts a system at a Separation from the
+	// first, approaching it at a relative Velocity along a
+	// straight line which would miss it by the
+	// ImpactParameter.
+	OrbitImpact = "impact"
+	// OrbitKepler starts a system at a Separation from the
+	// first on the way in along the two body orbit with the
+	// given Pericentre and Eccentricity, treating both
+	// systems as point masses.
+	OrbitKepler = "kepler"
+)
+
+// Scenario combines several systems, such as galaxies about to
+// collide, into the bodies of a single simulation.
+type Scenario struct {
+	// Systems are the systems combined. The first is placed
+	// at the origin and the rest on their Orbit around it.
+	Systems []System `json:"systems"`
+}
+
+// System is a set of bodies placed in a Scenario.
+type System struct {
+	// Spec generates the system's bodies, unless they are
+	// given in Bodies.
+	Spec   *Spec             `json:"spec,omitempty"`
+	Bodies []simulation.Body `json:"bodies,omitempty"`
+	// Name when set starts the name of each of the system's
+	// bodies, followed by a dash, so the systems can be told
+	// apart.
+	Name string `json:"name,omitempty"`
+	// Rotation turns the system about its center of mass
+	// before it is placed, none when it is nil.
+	Rotation *Rotation `json:"rotation,omitempty"`
+	// Orbit places the system relative to the first. It is
+	// ignored for the first system and required for the rest.
+	Orbit *Orbit `json:"orbit,omitempty"`
+}
+
+// Rotation turns a system, first by Inclination about the x
+// axis and then by Azimuth about the z axis, both in radians.
+// A disk in the xy plane ends up with its spin axis tilted
+// Inclination from the z axis.
+type Rotation struct {
+	Inclination float64 `json:"inclination"`
+	Azimuth     float64 `json:"azimuth"`
+}
+
+// Orbit is the path a system starts on relative to the first
+// system of a Scenario. Both kinds of orbit lie in the xy
+// plane, with the system starting on the negative x side and
+// passing closest to the first on the positive y side.
+type Orbit struct {
+	// Type is the kind of orbit, OrbitImpact or OrbitKepler.
+	Type string `json:"type"`
+	// Separation is the distance the system starts from the
+	// first.
+	Separation float64 `json:"separation"`
+	// ImpactParameter and Velocity describe an OrbitImpact.
+	ImpactParameter float64 `json:"impactParameter,omitempty"`
+	Velocity        float64 `json:"velocity,omitempty"`
+	// Pericentre and Eccentricity describe an OrbitKepler.
+	Pericentre   float64 `json:"pericentre,omitempty"`
+	Eccentricity float64 `json:"eccentricity,omitempty"`
+}
+
+// Count returns the number of bodies in the Scenario, see
+// Spec.Count.
+func (s Scenario) Count() int {
+	var n int
+	for _, system := range s.Systems {
+		if system.Spec != nil {
+			n = sum(n, system.Spec.Count())
+		} else {
+			n = sum(n, len(system.Bodies))
+		}
+	}
+	return n
+}
+
+// Validate checks the Scenario can be built.
+func (s Scenario) Validate() error {
+	if len(s.Systems) == 0 {
+		return fmt.Errorf("a scenario needs at least one system")
+	}
+
+	for i, system := range s.Systems {
+		if (system.Spec == nil) == (len(system.Bodies) == 0) {
+			return fmt.Errorf("system %d needs either a spec or bodies", i)
+		}
+		if system.Spec != nil {
+			if err := system.Spec.Validate(); err != nil {
+				return fmt.Errorf("system %d: %v", i, err)
+			}
+		}
+		if i == 0 {
+			continue
+		}
+
+		if system.Orbit == nil {
+			return fmt.Errorf("system %d needs an orbit", i)
+		}
+		if err := system.Orbit.validate(); err != nil {
+			return fmt.Errorf("system %d: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// validate checks the orbit can be followed.
+func (o *Orbit) validate() error {
+	if o.Separation <= 0 {
+		return fmt.Errorf("the separation must be strictly positive")
+	}
+
+	switch o.Type {
+	case OrbitImpact:
+		if o.ImpactParameter < 0 || o.ImpactParameter > o.Separation {
+			return fmt.Errorf("the impact parameter must be between 0 and the separation")
+		}
+		if o.Velocity < 0 {
+			return fmt.Errorf("the velocity must not be negative")
+		}
+	case OrbitKepler:
+		if o.Pericentre <= 0 {
+			return fmt.Errorf("the pericentre must be strictly positive")
+		}
+		if o.Eccentricity < 0 {
+			return fmt.Errorf("the eccentricity must not be negative")
+		}
+		if o.Separation < o.Pericentre {
+			return fmt.Errorf("the separation must be at least the pericentre")
+		}
+		if o.Eccentricity < 1 && o.Separation > o.Pericentre*(1+o.Eccentricity)/(1-o.Eccentricity) {
+			return fmt.Errorf("the separation must be at most the apocentre")
+		}
+	default:
+		return fmt.Errorf("unknown orbit type %q", o.Type)
+	}
+	return nil
+}
+
+// Bodies returns the bodies of every system of the scenario,
+// with grav the gravitational constant of the simulation they
+// are for. Each system is moved so its center of mass is at
+// rest at the origin, rotated and then placed on its orbit.
+// The bodies are then moved so the center of mass of them all
+// is at rest at the origin.
+func (s Scenario) Bodies(grav float64) ([]simulation.Body, error) {
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	if grav <= 0 {
+		return nil, fmt.Errorf("the gravitational constant must be strictly positive")
+	}
+
+	systems := make([][]simulation.Body, len(s.Systems))
+	for i, system := range s.Systems {
+		if system.Spec != nil {
+			bodies, err := Generate(*system.Spec, grav)
+			if err != nil {
+				return nil, fmt.Errorf("system %d: %v", i, err)
+			}
+			systems[i] = bodies
+		} else {
+			systems[i] = append([]simulation.Body(nil), system.Bodies...)
+		}
+
+		// Generated systems are not all centred, as a
+		// ModelPlanetary keeps its central body at the origin
+		recenter(systems[i])
+
+		if system.Rotation != nil {
+			system.Rotation.apply(systems[i])
+		}
+		if system.Name != "" {
+			for j := range systems[i] {
+				systems[i][j].Name = fmt.Sprintf("%s-%s", system.Name, systems[i][j].Name)
+			}
+		}
+	}
+
+	primary := total(systems[0])
+	all := systems[0]
+	for i, system := range s.Systems[1:] {
+		pos, vel := system.Orbit.state(grav * (primary + total(systems[i+1])))
+		for _, b := range systems[i+1] {
+			b.X += pos[0]
+			b.Y += pos[1]
+			b.Z += pos[2]
+			b.VX += vel[0]
+			b.VY += vel[1]
+			b.VZ += vel[2]
+			all = append(all, b)
+		}
+	}
+
+	recenter(all)
+	return all, nil
+}
+
+// state returns the position and velocity of a system on the
+// orbit relative to the first, with mu the gravitational
+// constant times the mass of both systems.
+func (o *Orbit) state(mu float64) (pos, vel [3]float64) {
+	d := o.Separation
+	if o.Type == OrbitImpact {
+		x := math.Sqrt(d*d - o.ImpactParameter*o.ImpactParameter)
+		return [3]float64{-x, o.ImpactParameter, 0}, [3]float64{o.Velocity, 0, 0}
+	}
+
+	// Find the true anomaly f, measured from the pericentre
+	// on the positive y axis, at which the orbit
+	// r = p / (1 + e cos f) reaches the separation on the
+	// way in
+	e := o.Eccentricity
+	p := o.Pericentre * (1 + e)
+	var f float64
+	if e > 0 {
+		f = -math.Acos(math.Max(-1, math.Min(1, (p/d-1)/e)))
+	}
+
+	// The orbit's velocity is sqrt(mu/p) (-sin f, e + cos f)
+	// with the pericentre along the x axis, which is mirrored
+	// in the line y = x to put the pericentre along the y axis
+	r := p / (1 + e*math.Cos(f))
+	x, y := r*math.Cos(f), r*math.Sin(f)
+	v := math.Sqrt(mu / p)
+	vx, vy := -v*math.Sin(f), v*(e+math.Cos(f))
+	return [3]float64{y, x, 0}, [3]float64{vy, vx, 0}
+}
+
+// apply turns the bodies about the origin.
+func (r *Rotation) apply(bodies []simulation.Body) {
+	ci, si := math.Cos(r.Inclination), math.Sin(r.Inclination)
+	ca, sa := math.Cos(r.Azimuth), math.Sin(r.Azimuth)
+	turn := func(x, y, z float64) (float64, float64, float64) {
+		// About the x axis by the inclination
+		y, z = ci*y-si*z, si*y+ci*z
+		// About the z axis by the azimuth
+		return ca*x - sa*y, sa*x + ca*y, z
+	}
+
+	for i := range bodies {
+		b := &bodies[i]
+		b.X, b.Y, b.Z = turn(b.X, b.Y, b.Z)
+		b.VX, b.VY, b.VZ = turn(b.VX, b.VY, b.VZ)
+	}
+}
+
+// total returns the total mass of the bodies.
+func total(bodies []simulation.Body) float64 {
+	var m float64
+	for _, b := range bodies {
+		m += b.Mass()
+	}
+	return m
+}
@@ -0,0 +1,166 @@
+package generate
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/tardisman5197/barnes-hut-sim/pkg/simulation"
+)
+
+func TestScenarioKepler(t *testing.T) {
+	scenario := Scenario{Systems: []System{
+		{Spec: &Spec{Model: ModelPlummer, N: 200, Mass: 3, ScaleRadius: 1, Seed: 1}, Name: "a"},
+		{
+			Spec:     &Spec{Model: ModelPlummer, N: 100, Mass: 1, ScaleRadius: 0.5, Seed: 2},
+			Name:     "b",
+			Rotation: &Rotation{Inclination: math.Pi / 3, Azimuth: 1},
+			Orbit:    &Orbit{Type: OrbitKepler, Separation: 20, Pericentre: 2, Eccentricity: 1},
+		},
+	}}
+
+	bodies, err := scenario.Bodies(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bodies) != 300 {
+		t.Fatalf("expected 300 bodies, got %d", len(bodies))
+	}
+
+	// Find the center of mass and velocity of each system
+	var m [2]float64
+	var pos, vel [2]simulation.Vector
+	for _, b := range bodies {
+		k := 0
+		if strings.HasPrefix(b.Name, "b-") {
+			k = 1
+		} else if !strings.HasPrefix(b.Name, "a-plummer-") {
+			t.Fatalf("unexpected body %s", b.Name)
+		}
+		m[k] += b.Mass()
+		pos[k] = pos[k].Add(simulation.Vector{X: b.X, Y: b.Y, Z: b.Z}.Scale(b.Mass()))
+		vel[k] = vel[k].Add(simulation.Vector{X: b.VX, Y: b.VY, Z: b.VZ}.Scale(b.Mass()))
+	}
+
+	// The combined center of mass is at rest at the origin
+	if pos[0].Add(pos[1]).Length() > 1e-9 || vel[0].Add(vel[1]).Length() > 1e-9 {
+		t.Fatalf("expected the center of mass at rest at the origin")
+	}
+
+	r := pos[1].Scale(1 / m[1]).Sub(pos[0].Scale(1 / m[0]))
+	v := vel[1].Scale(1 / m[1]).Sub(vel[0].Scale(1 / m[0]))
+	if math.Abs(r.Length()-20) > 1e-9 {
+		t.Fatalf("expected a separation of 20, got %v", r.Length())
+	}
+
+	// A parabolic orbit has no energy, and its angular
+	// momentum sets the pericentre
+	mu := m[0] + m[1]
+	if energy := v.Dot(v)/2 - mu/r.Length(); math.Abs(energy) > 1e-9 {
+		t.Fatalf("expected a parabolic orbit, got an energy of %v", energy)
+	}
+	h := r.Cross(v).Length()
+	if pericentre := h * h / (2 * mu); math.Abs(pericentre-2) > 1e-9 {
+		t.Fatalf("expected a pericentre of 2, got %v", pericentre)
+	}
+
+	// The systems are approaching
+	if r.Dot(v) >= 0 {
+		t.Fatal("expected the systems to be approaching")
+	}
+}
+
+func TestScenarioImpact(t *testing.T) {
+	bodies, err := Scenario{Systems: []System{
+		{Bodies: []simulation.Body{{Name: "target", X: 5, Radius: 1, Density: 1}}},
+		{
+			Bodies: []simulation.Body{{Name: "projectile", Radius: 1, Density: 1}},
+			Orbit:  &Orbit{Type: OrbitImpact, Separation: 10, ImpactParameter: 6, Velocity: 2},
+		},
+	}}.Bodies(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The loaded target is recentered, and the two bodies
+	// of equal mass end up either side of the origin
+	target, projectile := bodies[0], bodies[1]
+	if math.Abs(target.X-4) > 1e-9 || math.Abs(target.Y+3) > 1e-9 ||
+		math.Abs(projectile.X+4) > 1e-9 || math.Abs(projectile.Y-3) > 1e-9 {
+		t.Fatalf("unexpected positions %+v and %+v", target, projectile)
+	}
+	if math.Abs(projectile.VX-1) > 1e-9 || math.Abs(target.VX+1) > 1e-9 {
+		t.Fatalf("unexpected velocities %+v and %+v", target, projectile)
+	}
+}
+
+func TestScenarioRotation(t *testing.T) {
+	bodies := []simulation.Body{{X: 1, VY: 1}, {X: -1, VY: -1}}
+	(&Rotation{Inclination: math.Pi / 2, Azimuth: math.Pi / 2}).apply(bodies)
+
+	// Spinning about z, the disk is tilted onto its side and
+	// then turned to spin about x
+	b := bodies[0]
+	if math.Abs(b.Y-1) > 1e-12 || math.Abs(b.VZ-1) > 1e-12 {
+		t.Fatalf("unexpected rotated body %+v", b)
+	}
+}
+
+func TestScenarioPlanetary(t *testing.T) {
+	// A star with a heavy planet, which moves the center of
+	// mass away from the star
+	planetary := &Planetary{
+		Central: simulation.Body{Name: "star", Radius: 0.01, Density: 1 / (4.0 / 3.0 * math.Pi * 1e-6)},
+		Orbits: []Orbiting{{
+			Body:     simulation.Body{Name: "planet", Radius: 0.001, Density: 0.1 / (4.0 / 3.0 * math.Pi * 1e-9)},
+			Elements: Elements{SemiMajorAxis: 1},
+		}},
+	}
+	scenario := Scenario{Systems: []System{
+		{Bodies: []simulation.Body{{Name: "sun", Radius: 0.01, Density: 1 / (4.0 / 3.0 * math.Pi * 1e-6)}}},
+		{
+			Spec:  &Spec{Model: ModelPlanetary, Planetary: planetary},
+			Orbit: &Orbit{Type: OrbitImpact, Separation: 100},
+		},
+	}}
+
+	bodies, err := scenario.Bodies(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The system is placed by its center of mass, not its
+	// star, and starts at rest relative to the sun
+	var m float64
+	var pos, vel simulation.Vector
+	for _, b := range bodies[1:] {
+		m += b.Mass()
+		pos = pos.Add(simulation.Vector{X: b.X - bodies[0].X, Y: b.Y - bodies[0].Y, Z: b.Z - bodies[0].Z}.Scale(b.Mass()))
+		vel = vel.Add(simulation.Vector{X: b.VX - bodies[0].VX, Y: b.VY - bodies[0].VY, Z: b.VZ - bodies[0].VZ}.Scale(b.Mass()))
+	}
+	if d := pos.Scale(1 / m).Length(); math.Abs(d-100) > 1e-9 {
+		t.Fatalf("expected the planetary system's center of mass 100 from the sun, got %v", d)
+	}
+	if v := vel.Scale(1 / m).Length(); v > 1e-9 {
+		t.Fatalf("expected the planetary system to start at rest, got %v", v)
+	}
+}
+
+func TestScenarioInvalid(t *testing.T) {
+	spec := &Spec{Model: ModelPlummer, N: 10, Mass: 1, ScaleRadius: 1}
+	scenarios := []Scenario{
+		{},
+		{Systems: []System{{}}},
+		{Systems: []System{{Spec: spec}, {Spec: spec}}},
+		{Systems: []System{{Spec: spec}, {Spec: spec, Orbit: &Orbit{Type: OrbitKepler, Separation: 1, Pericentre: 2}}}},
+		{Systems: []System{{Spec: spec}, {Spec: spec, Orbit: &Orbit{Type: OrbitKepler, Separation: 10, Pericentre: 1, Eccentricity: 0.5}}}},
+		{Systems: []System{{Spec: spec}, {Spec: spec, Orbit: &Orbit{Type: OrbitImpact, Separation: 1, ImpactParameter: 2}}}},
+		{Systems: []System{{Spec: spec}, {Spec: spec, Orbit: &Orbit{Type: "spiral", Separation: 1}}}},
+	}
+
+	for _, s := range scenarios {
+		if _, err := s.Bodies(1); err == nil {
+			t.Fatalf("expected an error for %+v", s)
+		}
+	}
+}
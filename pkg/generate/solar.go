@@ -0,0 +1,141 @@
+package generate
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/tardisman5197/barnes-hut-sim/pkg/simulation"
+)
+
+const (
+	// ModelSolar is the Sun and the eight planets at the
+	// J2000 epoch, with lengths in astronomical units and
+	// masses in solar masses, see SolarSystem.
+	ModelSolar = "solar"
+	// ModelPlanetary is a central body with bodies on orbits
+	// around it given by their Elements, see Planetary.
+	ModelPlanetary = "planetary"
+
+	// SolarGrav is the gravitational constant in astronomical
+	// units, solar masses and years. Simulating SolarSystem
+	// with it gives times in years. It is Kepler's 4π², whose
+	// year is the Gaussian one, so is a few parts in 10^5
+	// larger than G in simulation.UnitsAstro, whose year is
+	// Julian.
+	SolarGrav = 4 * math.Pi * math.Pi
+
+	// au is the length of an astronomical unit in kilometres.
+	au = 149597870.7
+)
+
+// Planetary describes bodies orbiting a central body.
+type Planetary struct {
+	// Central is the body the others orbit. Its position and
+	// velocity are where the orbits are centered.
+	Central simulation.Body `json:"central"`
+	// Orbits are the bodies orbiting it.
+	Orbits []Orbiting `json:"orbits"`
+}
+
+// Orbiting is a body on an orbit given by its elements. The
+// body's position and velocity are replaced by those on the
+// orbit.
+type Orbiting struct {
+	simulation.Body
+	Elements Elements `json:"elements"`
+}
+
+// validate checks the planetary system can be generated.
+func (p *Planetary) validate() error {
+	if p.Central.Mass() <= 0 {
+		return fmt.Errorf("the central body must have a mass")
+	}
+	for _, o := range p.Orbits {
+		if err := o.Elements.Validate(); err != nil {
+			return fmt.Errorf("%s: %v", o.Name, err)
+		}
+	}
+	return nil
+}
+
+// bodies returns the central body followed by the bodies on
+// their orbits around it, with grav the gravitational constant
+// of the simulation.
+func (p *Planetary) bodies(grav float64) []simulation.Body {
+	bodies := []simulation.Body{p.Central}
+	for _, o := range p.Orbits {
+		bodies = append(bodies, Place(o.Body, p.Central, o.Elements, grav))
+	}
+	return bodies
+}
+
+// planet is a planet of the solar system, with its mean
+// elements at J2000 from Standish's "Keplerian Elements for
+// Approximate Positions of the Major Planets", in degrees
+// relative to the ecliptic.
+type planet struct {
+	name string
+	// mass is the reciprocal of the mass in solar masses and
+	// radius the mean radius in kilometres
+	mass, radius float64
+	// a is the semi-major axis in astronomical units, L the
+	// mean longitude, peri the longitude of perihelion and
+	// node the longitude of the ascending node
+	a, e, i, L, peri, node float64
+}
+
+// planets are the eight planets, the Earth being the
+// barycenter of the Earth and Moon.
+var planets = []planet{
+	{"Mercury", 6023600, 2439.7, 0.38709927, 0.20563593, 7.00497902, 252.25032350, 77.45779628, 48.33076593},
+	{"Venus", 408523.71, 6051.8, 0.72333566, 0.00677672, 3.39467605, 181.97909950, 131.60246718, 76.67984255},
+	{"Earth", 328900.56, 6371.0, 1.00000261, 0.01671123, -0.00001531, 100.46457166, 102.93768193, 0},
+	{"Mars", 3098708, 3389.5, 1.52371034, 0.09339410, 1.84969142, -4.55343205, -23.94362959, 49.55953891},
+	{"Jupiter", 1047.3486, 69911, 5.20288700, 0.04838624, 1.30439695, 34.39644051, 14.72847983, 100.47390909},
+	{"Saturn", 3497.898, 58232, 9.53667594, 0.05386179, 2.48599187, 49.95424423, 92.59887831, 113.66242448},
+	{"Uranus", 22902.98, 25362, 19.18916464, 0.04725744, 0.77263783, 313.23810451, 170.95427630, 74.01692503},
+	{"Neptune", 19412.24, 24622, 30.06992276, 0.00859048, 1.77004347, -55.12002969, 44.96476227, 131.78422574},
+}
+
+// sunRadius is the radius of the Sun in kilometres.
+const sunRadius = 695700
+
+// SolarSystem returns the Sun and the eight planets at the
+// J2000 epoch, with lengths in astronomical units, masses in
+// solar masses and the ecliptic as the xy plane. The time unit
+// is set by grav, SolarGrav giving years. The bodies are moved
+// so the barycenter is at rest at the origin.
+func SolarSystem(grav float64) []simulation.Body {
+	radius := sunRadius / au
+	system := Planetary{
+		Central: simulation.Body{Name: "Sun", Radius: radius, Density: density(1, radius)},
+	}
+
+	rad := math.Pi / 180
+	for _, p := range planets {
+		// The elements are given as longitudes, measured
+		// from the reference direction
+		inclination := p.i
+		node := p.node
+		if inclination < 0 {
+			inclination, node = -inclination, node+180
+		}
+
+		radius := p.radius / au
+		system.Orbits = append(system.Orbits, Orbiting{
+			Body: simulation.Body{Name: p.name, Radius: radius, Density: density(1/p.mass, radius)},
+			Elements: Elements{
+				SemiMajorAxis: p.a,
+				Eccentricity:  p.e,
+				Inclination:   inclination * rad,
+				Node:          angle(node * rad),
+				Periapsis:     angle((p.peri - node) * rad),
+				MeanAnomaly:   angle((p.L - p.peri) * rad),
+			},
+		})
+	}
+
+	bodies := system.bodies(grav)
+	recenter(bodies)
+	return bodies
+}
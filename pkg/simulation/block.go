@@ -0,0 +1,105 @@
+package simulation
+
+import "math"
+
+// blockStep advances the simulation by Timestep.MaxDt using
+// hierarchical block timesteps. Each body is placed in a bin
+// k with a timestep of MaxDt / 2^k, the smallest that its
+// timestep criterion allows, so bodies in dense regions take
+// many short steps while the rest take few long ones.
+//
+// The bins are integrated with kick-drift-kick leapfrog. All
+// bodies drift together on the shortest timestep, and only the
+// bodies whose step ends on a substep have their forces found
+// from the tree. The tree is refitted to the moved bodies
+// between substeps and only rebuilt when a body leaves its
+// leaf, or when more than RebuildThreshold of them have with
+// TreeReuse set, or when its leaves have grown so much that it
+// has lost more than DefaultDepthThreshold of its depth.
+func (s *Simulation) blockStep() {
+	if len(s.acc) != len(s.Bodies) {
+		s.acc = s.accelerations(s.Bodies)
+	}
+
+	dtMax := s.Timestep.MaxDt
+	substeps := 1 << uint(s.BlockLevels)
+	h := dtMax / float64(substeps)
+	length := s.timestepLength()
+
+	// level returns the finest bin the body at index i
+	// needs which is synchronised with the substep given.
+	level := func(i, substep int) int {
+		dt := s.Timestep.clamp(s.Timestep.bodyDt(s.Bodies[i], s.acc[i], length))
+		k := int(math.Ceil(math.Log2(dtMax / dt)))
+		if k < 0 {
+			k = 0
+		}
+		if k > s.BlockLevels {
+			k = s.BlockLevels
+		}
+
+		// A body can only move to a longer timestep when
+		// that bin is starting a new step
+		for substep%(substeps>>uint(k)) != 0 {
+			k++
+		}
+		return k
+	}
+	binDt := func(k int) float64 {
+		return dtMax / float64(int(1)<<uint(k))
+	}
+
+	// Place every body in a bin and give it the first
+	// half kick of its step
+	levels := make([]int, len(s.Bodies))
+	for i := range s.Bodies {
+		levels[i] = level(i, 0)
+		s.Bodies[i].kick(s.acc[i], binDt(levels[i])/2)
+	}
+
+	var threshold float64
+	if s.TreeReuse {
+		threshold = s.rebuildThreshold()
+	}
+
+	active := make([]int, 0, len(s.Bodies))
+	for substep := 1; substep <= substeps; substep++ {
+		for i := range s.Bodies {
+			s.Bodies[i].drift(h)
+		}
+
+		// Find the bodies which finish their step on
+		// this substep
+		active = active[:0]
+		for i := range s.Bodies {
+			if substep%(substeps>>uint(levels[i])) == 0 {
+				active = append(active, i)
+			}
+		}
+		if len(active) == 0 {
+			continue
+		}
+
+		// Reuse the tree from the last substep if the
+		// bodies are still inside their leaves
+		tree := s.reuseTree(s.Bodies, threshold)
+		acc := tree.AccelerationsOn(active, s.forceOptions())
+
+		for k, i := range active {
+			// Finish the step with the second half kick
+			s.acc[i] = acc[k]
+			s.Bodies[i].kick(s.acc[i], binDt(levels[i])/2)
+
+			// Start the next step in the bin the body
+			// now needs
+			if substep < substeps {
+				levels[i] = level(i, substep)
+				s.Bodies[i].kick(s.acc[i], binDt(levels[i])/2)
+			}
+		}
+	}
+
+	s.Dt = dtMax
+	s.Time += dtMax
+	s.DtHistory = append(s.DtHistory, dtMax)
+}
@@ -11,6 +11,10 @@ type Body struct {
 	X float64 `json:"x"`
 	Y float64 `json:"y"`
 	Z float64 `json:"z"`
+	// VX, VY, VZ stores the velocity of the body
+	VX float64 `json:"vx"`
+	VY float64 `json:"vy"`
+	VZ float64 `json:"vz"`
 	// Radius stores the radius of the body's sphere
 	Radius float64 `json:"radius"`
 	// Density stores the density of the material
@@ -21,17 +25,36 @@ type Body struct {
 // mass calculates the mass of the Body based
 // on the radius and density.
 func (b *Body) mass() float64 {
-	volume := (4 / 3) * math.Pi * math.Pow(b.Radius, 3)
+	volume := (4.0 / 3.0) * math.Pi * math.Pow(b.Radius, 3)
 
 	mass := volume * b.Density
 
 	return mass
 }
 
-// applyForce modifies the position of the Body
-// based on a force provided.
-func (b *Body) applyForce(fx, fy, fz float64) {
-	b.X += fx
-	b.Y += fy
-	b.Z += fz
+// Mass returns the mass of the Body, which comes from
+// its radius and density.
+func (b *Body) Mass() float64 {
+	return b.mass()
+}
+
+// kick changes the velocity of the Body by accelerating
+// it at a for a time of dt.
+func (b *Body) kick(a Vector, dt float64) {
+	b.VX += a.X * dt
+	b.VY += a.Y * dt
+	b.VZ += a.Z * dt
+}
+
+// drift moves the Body along its velocity for a time
+// of dt.
+func (b *Body) drift(dt float64) {
+	b.X += b.VX * dt
+	b.Y += b.VY * dt
+	b.Z += b.VZ * dt
+}
+
+// position returns the position of the Body.
+func (b *Body) position() Vector {
+	return Vector{b.X, b.Y, b.Z}
 }
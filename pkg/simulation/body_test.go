@@ -0,0 +1,14 @@
+package simulation
+
+import (
+	"math"
+	"testing"
+)
+
+func TestBodyMass(t *testing.T) {
+	// A sphere of radius 2 and density 3 has a mass of 32π
+	b := Body{Radius: 2, Density: 3}
+	if got, want := b.Mass(), 32*math.Pi; math.Abs(got-want) > 1e-12*want {
+		t.Fatalf("expected a mass of %v, got %v", want, got)
+	}
+}
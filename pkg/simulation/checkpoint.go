@@ -0,0 +1,350 @@
+package simulation
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// CheckpointVersion is the version of the checkpoint
+	// format written by WriteCheckpoint.
+	CheckpointVersion = 1
+)
+
+// checkpointMagic starts every checkpoint.
+var checkpointMagic = [4]byte{'B', 'H', 'S', 'C'}
+
+// checkpoint is the state of a simulation saved in a
+// checkpoint, its parameters and everything it keeps
+// between steps.
+type checkpoint struct {
+	Simulation *Simulation
+	// Acc are the accelerations kept from the last step.
+	Acc []Vector
+	// RNG is the state of the random number generator, nil
+	// when it has not been used yet.
+	RNG *uint64
+	// Tree is the structure of the tree kept for refitting,
+	// nil when there is none.
+	Tree *treeState
+}
+
+// treeState is the structure of an oct tree. The positions,
+// masses and moments are left out as they come from the
+// bodies when the tree is refitted.
+type treeState struct {
+	Index         []int
+	Keys          []uint64
+	X, Y, Z, Size float64
+	Capacity      int32
+	Nodes         []nodeState
+}
+
+// nodeState is the structure of an oct tree node.
+type nodeState struct {
+	First, Count, Child int32
+	Children, Level     uint8
+}
+
+// WriteCheckpoint writes the whole state of the simulation to
+// w, so that a simulation read back with ReadCheckpoint carries
+// on exactly as this one would. The Logger and observers are not
+// saved.
+//
+// A checkpoint starts with the bytes "BHSC", the format version
+// as a uint16, then the length of the payload as a uint64 and
+// its IEEE CRC-32 as a uint32, all little endian. The payload
+// follows, encoded with encoding/gob.
+func (s *Simulation) WriteCheckpoint(w io.Writer) error {
+	c := checkpoint{Acc: s.acc}
+
+	// The Logger cannot be encoded
+	sim := *s
+	sim.Logger = nil
+	c.Simulation = &sim
+
+	if s.rng != nil {
+		state := s.rng.state
+		c.RNG = &state
+	}
+	// A tree built for a different number of bodies is rebuilt
+	// on its next use, so it is not worth keeping
+	if s.tree != nil && len(s.tree.index) == len(s.Bodies) {
+		c.Tree = s.tree.state()
+	}
+
+	var payload bytes.Buffer
+	if err := gob.NewEncoder(&payload).Encode(&c); err != nil {
+		return fmt.Errorf("encoding the checkpoint: %v", err)
+	}
+
+	var header bytes.Buffer
+	header.Write(checkpointMagic[:])
+	binary.Write(&header, binary.LittleEndian, uint16(CheckpointVersion))
+	binary.Write(&header, binary.LittleEndian, uint64(payload.Len()))
+	binary.Write(&header, binary.LittleEndian, crc32.ChecksumIEEE(payload.Bytes()))
+
+	if _, err := w.Write(header.Bytes()); err != nil {
+		return err
+	}
+	_, err := w.Write(payload.Bytes())
+	return err
+}
+
+// ReadCheckpoint reads a simulation written by WriteCheckpoint.
+// An error is returned if the checkpoint is of an unknown version
+// or has been corrupted.
+func ReadCheckpoint(r io.Reader) (*Simulation, error) {
+	var magic [4]byte
+	var version uint16
+	var length uint64
+	var sum uint32
+	if err := binary.Read(r, binary.LittleEndian, &magic); err != nil || magic != checkpointMagic {
+		return nil, fmt.Errorf("not a simulation checkpoint")
+	}
+	if err := binary.Read(r, binary.LittleEndian, &version); err != nil {
+		return nil, err
+	}
+	if version != CheckpointVersion {
+		return nil, fmt.Errorf("unsupported checkpoint version %d", version)
+	}
+	if err := binary.Read(r, binary.LittleEndian, &length); err != nil {
+		return nil, err
+	}
+	if err := binary.Read(r, binary.LittleEndian, &sum); err != nil {
+		return nil, err
+	}
+
+	// Read no more than the payload, without trusting the
+	// length enough to allocate it up front
+	payload, err := ioutil.ReadAll(io.LimitReader(r, int64(length)))
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(payload)) != length {
+		return nil, fmt.Errorf("the checkpoint is truncated")
+	}
+	if crc32.ChecksumIEEE(payload) != sum {
+		return nil, fmt.Errorf("the checkpoint is corrupt, its checksum does not match")
+	}
+
+	var c checkpoint
+	if err := gob.NewDecoder(bytes.NewReader(payload)).Decode(&c); err != nil {
+		return nil, fmt.Errorf("decoding the checkpoint: %v", err)
+	}
+	if c.Simulation == nil {
+		return nil, fmt.Errorf("the checkpoint has no simulation")
+	}
+
+	s := c.Simulation
+	s.acc = c.Acc
+	if c.RNG != nil {
+		s.rng = &rng{state: *c.RNG}
+	}
+	if c.Tree != nil {
+		tree, err := c.Tree.tree(s.Bodies, s.treeOptions())
+		if err != nil {
+			return nil, err
+		}
+		s.tree = tree
+	}
+	return s, nil
+}
+
+// SaveCheckpoint writes a checkpoint of the simulation to the
+// file at path. The checkpoint is written to a temporary file
+// first and then moved into place, so the file always holds a
+// whole checkpoint.
+func (s *Simulation) SaveCheckpoint(path string) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	w := bufio.NewWriter(f)
+	if err := s.WriteCheckpoint(w); err != nil {
+		f.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// LoadCheckpoint reads a simulation from the checkpoint file
+// at path.
+func LoadCheckpoint(path string) (*Simulation, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	s, err := ReadCheckpoint(bufio.NewReader(f))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return s, nil
+}
+
+// state returns the structure of the tree.
+func (t *Octree) state() *treeState {
+	state := &treeState{
+		Index:    t.index,
+		Keys:     t.keys,
+		X:        t.x,
+		Y:        t.y,
+		Z:        t.z,
+		Size:     t.size,
+		Capacity: t.capacity,
+		Nodes:    make([]nodeState, len(t.nodes)),
+	}
+	for i := range t.nodes {
+		n := &t.nodes[i]
+		state.Nodes[i] = nodeState{
+			First:    n.first,
+			Count:    n.count,
+			Child:    n.child,
+			Children: n.children,
+			Level:    n.level,
+		}
+	}
+	return state
+}
+
+// validate checks the structure is one that refit can be given
+// for n bodies: the index holds each body once, the keys are in
+// order, and the nodes form a single tree whose root holds every
+// body and whose nodes share the cube of their keys. The children
+// of a node split its bodies between them in order, one level
+// deeper, and every node but the root is the child of one other.
+func (state *treeState) validate(n int) error {
+	if len(state.Index) != n || len(state.Keys) != n || len(state.Nodes) == 0 {
+		return fmt.Errorf("the checkpoint's oct tree does not match its bodies")
+	}
+	invalid := fmt.Errorf("the checkpoint's oct tree is not valid")
+
+	if !(state.Size > 0) || math.IsInf(state.Size, 0) || state.Capacity < 1 {
+		return invalid
+	}
+
+	seen := make([]bool, n)
+	for _, i := range state.Index {
+		if i < 0 || i >= n || seen[i] {
+			return invalid
+		}
+		seen[i] = true
+	}
+	for s := 1; s < n; s++ {
+		if state.Keys[s] < state.Keys[s-1] {
+			return invalid
+		}
+	}
+
+	root := state.Nodes[0]
+	if root.First != 0 || int(root.Count) != n || root.Level != 0 {
+		return invalid
+	}
+
+	parented := make([]bool, len(state.Nodes))
+	for i, node := range state.Nodes {
+		first, count := int(node.First), int(node.Count)
+		if first < 0 || count < 0 || first+count > n || node.Level > mortonBits {
+			return invalid
+		}
+
+		// The sorted keys of the node's first and last bodies
+		// sharing its cube means they all do
+		if count > 0 {
+			shift := uint(3 * (mortonBits - int(node.Level)))
+			if state.Keys[first]>>shift != state.Keys[first+count-1]>>shift {
+				return invalid
+			}
+		}
+
+		if node.Children == 0 {
+			continue
+		}
+		child, children := int(node.Child), int(node.Children)
+		if children > 8 || child <= i || child+children > len(state.Nodes) {
+			return invalid
+		}
+		next := first
+		for c := child; c < child+children; c++ {
+			if parented[c] || int(state.Nodes[c].First) != next || state.Nodes[c].Level != node.Level+1 {
+				return invalid
+			}
+			parented[c] = true
+			next += int(state.Nodes[c].Count)
+		}
+		if next != first+count {
+			return invalid
+		}
+	}
+	for i := 1; i < len(parented); i++ {
+		if !parented[i] {
+			return invalid
+		}
+	}
+	return nil
+}
+
+// tree rebuilds the tree with this structure, refitted to the
+// bodies.
+func (state *treeState) tree(bodies []Body, opts TreeOptions) (*Octree, error) {
+	if err := state.validate(len(bodies)); err != nil {
+		return nil, err
+	}
+
+	n := len(bodies)
+	t := &Octree{
+		bodies:   bodies,
+		index:    state.Index,
+		sorted:   make([]int, n),
+		keys:     state.Keys,
+		pos:      make([]Vector, n),
+		mass:     make([]float64, n),
+		x:        state.X,
+		y:        state.Y,
+		z:        state.Z,
+		size:     state.Size,
+		workers:  workerCount(opts.Workers),
+		capacity: state.Capacity,
+		nodes:    make([]OctNode, len(state.Nodes)),
+	}
+	t.setDepthThreshold(opts.DepthThreshold)
+	for s, i := range t.index {
+		t.sorted[i] = s
+	}
+	for i, node := range state.Nodes {
+		t.nodes[i] = OctNode{
+			first:    node.First,
+			count:    node.Count,
+			child:    node.Child,
+			children: node.Children,
+			level:    node.Level,
+		}
+	}
+
+	t.refit(bodies)
+	return t, nil
+}
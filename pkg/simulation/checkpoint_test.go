@@ -0,0 +1,167 @@
+package simulation
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCheckpointRestart(t *testing.T) {
+	bodies := cloud(60, 29)
+	for i := range bodies {
+		bodies[i].Name = string(rune('A' + i%26))
+	}
+
+	newSim := func() *Simulation {
+		sim := NewSimulation(1, 0.6, bodies...)
+		sim.Softening = 0.02
+		sim.Opening = MACRelative
+		sim.Alpha = 0.001
+		sim.Order = OrderQuadrupole
+		sim.Timestep = &Timestep{Eta: 0.05, MinDt: 1e-5, MaxDt: 0.01}
+		sim.BlockLevels = 2
+		sim.TreeReuse = true
+		sim.Collisions = CollisionFragment
+		sim.Seed = 7
+		return sim
+	}
+
+	uninterrupted := newSim()
+	uninterrupted.Steps(20)
+
+	sim := newSim()
+	sim.Steps(10)
+
+	var buf bytes.Buffer
+	if err := sim.WriteCheckpoint(&buf); err != nil {
+		t.Fatal(err)
+	}
+	restarted, err := ReadCheckpoint(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restarted.Steps(10)
+
+	if !reflect.DeepEqual(restarted.Bodies, uninterrupted.Bodies) {
+		t.Fatal("expected the restarted bodies to be the same as an uninterrupted run")
+	}
+	if restarted.Step != uninterrupted.Step || restarted.Time != uninterrupted.Time {
+		t.Fatalf("expected step %d at time %v, got %d at %v", uninterrupted.Step, uninterrupted.Time, restarted.Step, restarted.Time)
+	}
+	if !reflect.DeepEqual(restarted.DtHistory, uninterrupted.DtHistory) {
+		t.Fatal("expected the restarted timesteps to be the same as an uninterrupted run")
+	}
+}
+
+func TestCheckpointCorrupt(t *testing.T) {
+	sim := NewSimulation(1, 0.5, pair()...)
+	sim.Steps(2)
+
+	var buf bytes.Buffer
+	if err := sim.WriteCheckpoint(&buf); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	corrupt := append([]byte(nil), data...)
+	corrupt[len(corrupt)-5] ^= 0xff
+	if _, err := ReadCheckpoint(bytes.NewReader(corrupt)); err == nil {
+		t.Fatal("expected an error for a corrupt checkpoint")
+	}
+
+	if _, err := ReadCheckpoint(bytes.NewReader(data[:len(data)-1])); err == nil {
+		t.Fatal("expected an error for a truncated checkpoint")
+	}
+
+	future := append([]byte(nil), data...)
+	future[4] = CheckpointVersion + 1
+	if _, err := ReadCheckpoint(bytes.NewReader(future)); err == nil {
+		t.Fatal("expected an error for an unknown version")
+	}
+}
+
+func TestCheckpointFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sim := NewSimulation(1, 0.5, pair()...)
+	sim.Steps(3)
+
+	path := filepath.Join(dir, "sim.ckpt")
+	if err := sim.SaveCheckpoint(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded.Bodies, sim.Bodies) || loaded.Step != 3 {
+		t.Fatalf("expected the saved simulation back, got %+v", loaded)
+	}
+
+	// Only the checkpoint is left in the directory
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 1 {
+		t.Fatalf("expected a single file, found %d", len(files))
+	}
+}
+
+func TestCheckpointInvalidTree(t *testing.T) {
+	// Each change leaves a checkpoint with a valid checksum
+	// but a tree which cannot be refitted
+	changes := map[string]func(tree *Octree){
+		"negative count": func(tree *Octree) {
+			tree.nodes[len(tree.nodes)-1].count = -1
+		},
+		"overflowing range": func(tree *Octree) {
+			tree.nodes[len(tree.nodes)-1].first = math.MaxInt32
+			tree.nodes[len(tree.nodes)-1].count = math.MaxInt32
+		},
+		"repeated body": func(tree *Octree) {
+			tree.index[1] = tree.index[0]
+		},
+		"unsorted keys": func(tree *Octree) {
+			tree.keys[0], tree.keys[len(tree.keys)-1] = tree.keys[len(tree.keys)-1], tree.keys[0]
+		},
+		"partial root": func(tree *Octree) {
+			tree.nodes[0].count--
+		},
+		"child before its parent": func(tree *Octree) {
+			last := &tree.nodes[len(tree.nodes)-1]
+			last.child, last.children = tree.nodes[0].child, tree.nodes[0].children
+		},
+		"no size": func(tree *Octree) {
+			tree.size = 0
+		},
+	}
+
+	for name, change := range changes {
+		sim := NewSimulation(1, 0.5, cloud(50, 3)...)
+		sim.TreeReuse = true
+		sim.Steps(1)
+
+		var buf bytes.Buffer
+		if err := sim.WriteCheckpoint(&buf); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ReadCheckpoint(bytes.NewReader(buf.Bytes())); err != nil {
+			t.Fatalf("expected the unchanged checkpoint to load, got %v", err)
+		}
+
+		change(sim.tree)
+		buf.Reset()
+		if err := sim.WriteCheckpoint(&buf); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ReadCheckpoint(&buf); err == nil {
+			t.Fatalf("expected an error for a tree with a %s", name)
+		}
+	}
+}
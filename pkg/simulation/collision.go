@@ -0,0 +1,295 @@
+package simulation
+
+import (
+	"fmt"
+	"math"
+)
+
+const (
+	// CollisionNone lets bodies pass through each other.
+	CollisionNone = "none"
+	// CollisionMerge combines overlapping bodies into one,
+	// conserving mass and momentum.
+	CollisionMerge = "merge"
+	// CollisionBounce pushes overlapping bodies apart,
+	// losing energy according to the restitution
+	// coefficient.
+	CollisionBounce = "bounce"
+	// CollisionFragment merges bodies that collide slower
+	// than their mutual escape velocity and breaks faster
+	// collisions into fragments.
+	CollisionFragment = "fragment"
+
+	// DefaultFragments is the number of fragments a body
+	// breaks into when none has been chosen.
+	DefaultFragments = 4
+)
+
+// Event records something that happened to bodies during
+// a simulation, such as a collision.
+type Event struct {
+	// Step and Time are when the event happened.
+	Step int     `json:"step"`
+	Time float64 `json:"time"`
+	// Type is the kind of event, the collision response
+	// for collisions.
+	Type string `json:"type"`
+	// Bodies are the names of the bodies involved.
+	Bodies []string `json:"bodies"`
+	// Result are the names of the bodies produced.
+	Result []string `json:"result,omitempty"`
+}
+
+// validateCollisions returns an error if the collision
+// response is not known.
+func validateCollisions(mode string) error {
+	switch mode {
+	case "", CollisionNone, CollisionMerge, CollisionBounce, CollisionFragment:
+		return nil
+	}
+	return fmt.Errorf("unknown collision response %q", mode)
+}
+
+// collide finds the bodies whose spheres overlap and applies
+// the simulation's collision response to them. Each body takes
+// part in at most one collision per step.
+func (s *Simulation) collide() {
+	if s.Collisions == "" || s.Collisions == CollisionNone || len(s.Bodies) < 2 {
+		return
+	}
+
+	tree := NewOctree(s.Bodies, s.treeOptions())
+
+	maxRadius := 0.0
+	for i := range s.Bodies {
+		maxRadius = math.Max(maxRadius, s.Bodies[i].Radius)
+	}
+
+	removed := make([]bool, len(s.Bodies))
+	var added []Body
+	changed := false
+
+	for i := range s.Bodies {
+		if removed[i] {
+			continue
+		}
+
+		// Only bodies closer than the largest radius can
+		// overlap with the body
+		b := s.Bodies[i]
+		for _, j := range tree.WithinRadius(b.position(), b.Radius+maxRadius) {
+			if j <= i || removed[j] {
+				continue
+			}
+			if s.Bodies[j].position().Sub(b.position()).Length() >= b.Radius+s.Bodies[j].Radius {
+				continue
+			}
+
+			var result []Body
+			var event string
+			switch s.Collisions {
+			case CollisionMerge:
+				result, event = []Body{merge(s.Bodies[i], s.Bodies[j])}, CollisionMerge
+			case CollisionBounce:
+				if !bounce(&s.Bodies[i], &s.Bodies[j], s.Restitution) {
+					continue
+				}
+				event = CollisionBounce
+			case CollisionFragment:
+				result, event = s.fragment(s.Bodies[i], s.Bodies[j])
+			}
+
+			s.event(Event{
+				Step:   s.Step,
+				Time:   s.Time,
+				Type:   event,
+				Bodies: []string{s.Bodies[i].Name, s.Bodies[j].Name},
+				Result: names(result),
+			})
+
+			// The first body produced takes the place of the
+			// first body in the collision
+			if len(result) > 0 {
+				s.Bodies[i] = result[0]
+				removed[j] = true
+				added = append(added, result[1:]...)
+				changed = true
+			}
+			break
+		}
+	}
+
+	if !changed {
+		return
+	}
+
+	// Remove the bodies that were merged away and add any
+	// fragments to the end
+	bodies := s.Bodies[:0]
+	for i := range s.Bodies {
+		if !removed[i] {
+			bodies = append(bodies, s.Bodies[i])
+		}
+	}
+	s.Bodies = append(bodies, added...)
+
+	// The masses and positions have changed so the
+	// accelerations have to be found again
+	s.acc = nil
+}
+
+// names returns the names of the bodies.
+func names(bodies []Body) []string {
+	var n []string
+	for _, b := range bodies {
+		n = append(n, b.Name)
+	}
+	return n
+}
+
+// merge returns a single body with the combined mass and
+// momentum of the bodies a and b, at their centre of mass.
+// Its radius is found from their combined volume so that the
+// density is the mass weighted density of the two. It takes
+// the name of the more massive body.
+func merge(a, b Body) Body {
+	ma, mb := a.mass(), b.mass()
+	m := ma + mb
+
+	merged := a
+	if mb > ma {
+		merged = b
+	}
+
+	volume := (4.0 / 3.0) * math.Pi * (math.Pow(a.Radius, 3) + math.Pow(b.Radius, 3))
+	merged.Radius = math.Cbrt(volume / ((4.0 / 3.0) * math.Pi))
+	merged.Density = m / volume
+
+	if m > 0 {
+		merged.X = (ma*a.X + mb*b.X) / m
+		merged.Y = (ma*a.Y + mb*b.Y) / m
+		merged.Z = (ma*a.Z + mb*b.Z) / m
+		merged.VX = (ma*a.VX + mb*b.VX) / m
+		merged.VY = (ma*a.VY + mb*b.VY) / m
+		merged.VZ = (ma*a.VZ + mb*b.VZ) / m
+	}
+
+	return merged
+}
+
+// bounce changes the velocities of the bodies a and b as if
+// they collided, conserving momentum. The restitution is the
+// fraction of their approach speed they separate at, 1 being
+// perfectly elastic. It returns false if the bodies are
+// already moving apart, in which case nothing is changed.
+func bounce(a, b *Body, restitution float64) bool {
+	ma, mb := a.mass(), b.mass()
+	if ma <= 0 || mb <= 0 {
+		return false
+	}
+
+	// Find the direction between the centres
+	normal := Vector{b.X - a.X, b.Y - a.Y, b.Z - a.Z}
+	d := normal.Length()
+	if d == 0 {
+		return false
+	}
+	normal = normal.Scale(1 / d)
+
+	// Only bodies moving towards each other bounce
+	vn := Vector{b.VX - a.VX, b.VY - a.VY, b.VZ - a.VZ}.Dot(normal)
+	if vn >= 0 {
+		return false
+	}
+
+	// Exchange an impulse along the normal
+	impulse := -(1 + restitution) * vn / (1/ma + 1/mb)
+	a.kick(normal, -impulse/ma)
+	b.kick(normal, impulse/mb)
+
+	return true
+}
+
+// fragment returns the result of a collision between a and b.
+// When they collide slower than their mutual escape velocity
+// they merge, otherwise they break into equal fragments which
+// fly apart in pairs, conserving mass and momentum.
+func (s *Simulation) fragment(a, b Body) ([]Body, string) {
+	ma, mb := a.mass(), b.mass()
+	m := ma + mb
+
+	relative := Vector{b.VX - a.VX, b.VY - a.VY, b.VZ - a.VZ}
+	impact := relative.Dot(relative)
+	escape := 2 * s.Grav * m / (a.Radius + b.Radius)
+	if impact <= escape || m <= 0 {
+		return []Body{merge(a, b)}, CollisionMerge
+	}
+
+	count := s.Fragments
+	if count <= 0 {
+		count = DefaultFragments
+	}
+	// Fragments are made in opposite pairs
+	count += count % 2
+
+	merged := merge(a, b)
+
+	// Each fragment has an equal share of the volume
+	fragment := merged
+	fragment.Radius = merged.Radius / math.Cbrt(float64(count))
+
+	// Place the fragments evenly around a circle in a random
+	// plane, far enough apart that they do not overlap
+	u, v := s.randomPlane()
+	distance := math.Max(a.Radius+b.Radius, 1.01*fragment.Radius/math.Sin(math.Pi/float64(count)))
+
+	// The kinetic energy of the impact beyond what is needed
+	// to escape is shared between the fragments
+	reduced := ma * mb / m
+	speed := math.Sqrt(reduced * (impact - escape) / m)
+
+	offset := 2 * math.Pi * s.random().float64()
+	fragments := make([]Body, count)
+	for i := 0; i < count; i++ {
+		angle := offset + 2*math.Pi*float64(i)/float64(count)
+		direction := u.Scale(math.Cos(angle)).Add(v.Scale(math.Sin(angle)))
+
+		fragments[i] = fragment
+		fragments[i].Name = fmt.Sprintf("%s-%d", merged.Name, i+1)
+		fragments[i].X += direction.X * distance
+		fragments[i].Y += direction.Y * distance
+		fragments[i].Z += direction.Z * distance
+		fragments[i].kick(direction, speed)
+	}
+
+	return fragments, CollisionFragment
+}
+
+// random returns the simulation's random number generator,
+// seeding it on first use.
+func (s *Simulation) random() *rng {
+	if s.rng == nil {
+		s.rng = newRNG(s.Seed)
+	}
+	return s.rng
+}
+
+// randomPlane returns two perpendicular unit vectors which
+// span a plane with a random orientation.
+func (s *Simulation) randomPlane() (u, v Vector) {
+	// Pick a random normal uniformly over the sphere
+	z := 2*s.random().float64() - 1
+	phi := 2 * math.Pi * s.random().float64()
+	normal := Vector{math.Sqrt(1-z*z) * math.Cos(phi), math.Sqrt(1-z*z) * math.Sin(phi), z}
+
+	// Any vector not parallel to the normal gives the plane
+	axis := Vector{1, 0, 0}
+	if math.Abs(normal.X) > 0.9 {
+		axis = Vector{0, 1, 0}
+	}
+	u = normal.Cross(axis)
+	u = u.Scale(1 / u.Length())
+	v = normal.Cross(u)
+
+	return u, v
+}
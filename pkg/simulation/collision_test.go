@@ -0,0 +1,96 @@
+package simulation
+
+import (
+	"math"
+	"testing"
+)
+
+// headOn returns two bodies about to collide head on.
+func headOn() []Body {
+	return []Body{
+		{Name: "small", X: -0.011, VX: 1, Radius: 0.01, Density: unitMassDensity},
+		{Name: "large", X: 0.011, VX: -0.5, Radius: 0.01, Density: 2 * unitMassDensity},
+	}
+}
+
+// momentum returns the total mass and momentum of the bodies.
+func momentum(bodies []Body) (float64, Vector) {
+	var m float64
+	var p Vector
+	for i := range bodies {
+		m += bodies[i].mass()
+		p = p.Add(Vector{bodies[i].VX, bodies[i].VY, bodies[i].VZ}.Scale(bodies[i].mass()))
+	}
+	return m, p
+}
+
+func TestCollisionMerge(t *testing.T) {
+	sim := NewSimulation(0, 0, headOn()...)
+	sim.Collisions = CollisionMerge
+	mass, p := momentum(sim.Bodies)
+
+	sim.Steps(1)
+
+	if len(sim.Bodies) != 1 {
+		t.Fatalf("expected the bodies to merge, found %d bodies", len(sim.Bodies))
+	}
+	if sim.Bodies[0].Name != "large" {
+		t.Fatalf("expected the merged body to be named large, got %s", sim.Bodies[0].Name)
+	}
+
+	m, q := momentum(sim.Bodies)
+	if math.Abs(m-mass) > 1e-9 || q.Sub(p).Length() > 1e-9 {
+		t.Fatalf("mass or momentum not conserved: %f %v != %f %v", m, q, mass, p)
+	}
+
+	if len(sim.Events) != 1 || sim.Events[0].Type != CollisionMerge {
+		t.Fatalf("expected a single merge event, got %v", sim.Events)
+	}
+	if sim.Events[0].Bodies[0] != "small" || sim.Events[0].Bodies[1] != "large" {
+		t.Fatalf("unexpected bodies in merge event %v", sim.Events[0].Bodies)
+	}
+}
+
+func TestCollisionBounce(t *testing.T) {
+	bodies := headOn()
+	bodies[1].Density = unitMassDensity
+
+	sim := NewSimulation(0, 0, bodies...)
+	sim.Collisions = CollisionBounce
+	sim.Restitution = 1
+	sim.Steps(1)
+
+	// Equal masses exchange their velocities in an
+	// elastic collision
+	if math.Abs(sim.Bodies[0].VX+0.5) > 1e-9 || math.Abs(sim.Bodies[1].VX-1) > 1e-9 {
+		t.Fatalf("unexpected velocities after bounce %f %f", sim.Bodies[0].VX, sim.Bodies[1].VX)
+	}
+
+	// Bodies moving apart do not bounce again
+	sim.Steps(1)
+	if len(sim.Events) != 1 {
+		t.Fatalf("expected a single bounce event, got %d", len(sim.Events))
+	}
+}
+
+func TestCollisionFragment(t *testing.T) {
+	sim := NewSimulation(0, 0, headOn()...)
+	sim.Collisions = CollisionFragment
+	sim.Fragments = 6
+	mass, p := momentum(sim.Bodies)
+
+	sim.Steps(1)
+
+	if len(sim.Bodies) != 6 {
+		t.Fatalf("expected 6 fragments, found %d bodies", len(sim.Bodies))
+	}
+
+	m, q := momentum(sim.Bodies)
+	if math.Abs(m-mass) > 1e-9 || q.Sub(p).Length() > 1e-9 {
+		t.Fatalf("mass or momentum not conserved: %f %v != %f %v", m, q, mass, p)
+	}
+
+	if len(sim.Events) != 1 || len(sim.Events[0].Result) != 6 {
+		t.Fatalf("expected a fragment event with 6 results, got %v", sim.Events)
+	}
+}
@@ -0,0 +1,76 @@
+package simulation
+
+// Diagnostics holds the physical quantities of a simulation
+// at the end of a step. Quantities which should be conserved
+// drifting over time shows the run is not trustworthy.
+type Diagnostics struct {
+	// Step and Time are when the diagnostics were taken.
+	Step int     `json:"step"`
+	Time float64 `json:"time"`
+	// Kinetic and Potential are the kinetic and gravitational
+	// potential energy of the bodies and Total their sum.
+	Kinetic   float64 `json:"kinetic"`
+	Potential float64 `json:"potential"`
+	Total     float64 `json:"total"`
+	// Momentum and AngularMomentum are the total linear and
+	// angular momentum of the bodies about the origin.
+	Momentum        Vector `json:"momentum"`
+	AngularMomentum Vector `json:"angularMomentum"`
+	// CenterOfMass is the position of the center of mass.
+	CenterOfMass Vector `json:"centerOfMass"`
+	// Virial is the virial ratio 2K/|W|, 1 for a system in
+	// equilibrium.
+	Virial float64 `json:"virial"`
+}
+
+// Diagnose calculates the diagnostics of the simulation's
+// current state. The potential energy is found with the oct
+// tree, so it has the same accuracy as the forces.
+func (s *Simulation) Diagnose() Diagnostics {
+	d := Diagnostics{
+		Step: s.Step,
+		Time: s.Time,
+	}
+	if len(s.Bodies) == 0 {
+		return d
+	}
+
+	var mass float64
+	var weighted Vector
+	for i := range s.Bodies {
+		b := &s.Bodies[i]
+		m := b.mass()
+		position := Vector{b.X, b.Y, b.Z}
+		velocity := Vector{b.VX, b.VY, b.VZ}
+
+		mass += m
+		weighted = weighted.Add(position.Scale(m))
+		d.Kinetic += 0.5 * m * velocity.Dot(velocity)
+		d.Momentum = d.Momentum.Add(velocity.Scale(m))
+		d.AngularMomentum = d.AngularMomentum.Add(position.Cross(velocity).Scale(m))
+	}
+	if mass > 0 {
+		d.CenterOfMass = weighted.Scale(1 / mass)
+	}
+
+	d.Potential = NewOctree(s.Bodies, s.treeOptions()).Potential(s.forceOptions())
+
+	d.Total = d.Kinetic + d.Potential
+	if d.Potential != 0 {
+		d.Virial = 2 * d.Kinetic / -d.Potential
+	}
+
+	return d
+}
+
+// diagnose records the diagnostics of the simulation when
+// they are due.
+func (s *Simulation) diagnose() {
+	every := s.DiagnosticsEvery
+	if every <= 0 {
+		every = 1
+	}
+	if s.Step%every == 0 {
+		s.Diagnostics = append(s.Diagnostics, s.Diagnose())
+	}
+}
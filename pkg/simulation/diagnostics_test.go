@@ -0,0 +1,55 @@
+package simulation
+
+import (
+	"math"
+	"testing"
+)
+
+func TestDiagnostics(t *testing.T) {
+	sim := NewSimulation(1, 0, pair()...)
+	sim.Dt = 0.01
+	sim.Steps(400)
+
+	if len(sim.Diagnostics) != 401 {
+		t.Fatalf("expected 401 diagnostics, got %d", len(sim.Diagnostics))
+	}
+
+	// The circular binary starts with K = 1/2, W = -1
+	start := sim.Diagnostics[0]
+	if math.Abs(start.Kinetic-0.5) > 1e-9 || math.Abs(start.Potential+1) > 1e-9 {
+		t.Fatalf("unexpected starting energies K=%f W=%f", start.Kinetic, start.Potential)
+	}
+	if math.Abs(start.Virial-1) > 1e-9 {
+		t.Fatalf("expected a virial ratio of 1, got %f", start.Virial)
+	}
+
+	for _, d := range sim.Diagnostics {
+		if drift := math.Abs((d.Total - start.Total) / start.Total); drift > 1e-4 {
+			t.Fatalf("step %d: energy drifted by %g", d.Step, drift)
+		}
+		if d.Momentum.Length() > 1e-9 {
+			t.Fatalf("step %d: momentum not conserved %v", d.Step, d.Momentum)
+		}
+		if d.AngularMomentum.Sub(start.AngularMomentum).Length() > 1e-9 {
+			t.Fatalf("step %d: angular momentum not conserved %v", d.Step, d.AngularMomentum)
+		}
+		if d.CenterOfMass.Length() > 1e-9 {
+			t.Fatalf("step %d: center of mass moved to %v", d.Step, d.CenterOfMass)
+		}
+	}
+}
+
+func TestMultipolePotential(t *testing.T) {
+	bodies := cloud(200, 4)
+
+	exact := NewSimulation(1, 0, bodies...)
+	want := exact.Diagnose().Potential
+
+	for _, order := range []int{OrderMonopole, OrderQuadrupole, OrderOctupole} {
+		sim := NewSimulation(1, 0.5, bodies...)
+		sim.Order = order
+		if got := sim.Diagnose().Potential; math.Abs((got-want)/want) > 1e-3 {
+			t.Fatalf("order %d: potential %f too far from %f", order, got, want)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package simulation
+
+import (
+	"math"
+	"sort"
+)
+
+// DirectAccelerations returns the acceleration of each body
+// found by summing the force from every other body directly.
+// It takes O(N^2) time but has no approximation error, so it
+// is the reference the tree forces are compared against. The
+// gravitational constant and softening are those of the
+// simulation.
+func (s *Simulation) DirectAccelerations() []Vector {
+	acc := make([]Vector, len(s.Bodies))
+
+	masses := make([]float64, len(s.Bodies))
+	for i := range s.Bodies {
+		masses[i] = s.Bodies[i].mass()
+	}
+
+	// Each pair is visited once and the force applied
+	// to both bodies
+	for i := range s.Bodies {
+		for j := i + 1; j < len(s.Bodies); j++ {
+			d := Vector{
+				s.Bodies[j].X - s.Bodies[i].X,
+				s.Bodies[j].Y - s.Bodies[i].Y,
+				s.Bodies[j].Z - s.Bodies[i].Z,
+			}
+			g := s.Grav * softenedForce(s.Kernel, d.Length(), s.Softening)
+
+			acc[i] = acc[i].Add(d.Scale(g * masses[j]))
+			acc[j] = acc[j].Sub(d.Scale(g * masses[i]))
+		}
+	}
+
+	return acc
+}
+
+// AccuracyReport describes how far the forces calculated
+// with the oct tree are from those of direct summation.
+type AccuracyReport struct {
+	// Theta is the opening angle the tree forces were
+	// calculated with.
+	Theta float64 `json:"theta"`
+	// Errors holds the relative error of the acceleration
+	// of each body, |a_tree - a_direct| / |a_direct|.
+	Errors []float64 `json:"errors"`
+	// Median, P99 and Max summarise the relative errors.
+	Median float64 `json:"median"`
+	P99    float64 `json:"p99"`
+	Max    float64 `json:"max"`
+}
+
+// ForceAccuracy compares the accelerations the oct tree
+// gives the bodies against direct summation.
+func (s *Simulation) ForceAccuracy() AccuracyReport {
+	tree := s.accelerations(s.Bodies)
+	direct := s.DirectAccelerations()
+
+	report := AccuracyReport{
+		Theta:  s.Theta,
+		Errors: make([]float64, len(s.Bodies)),
+	}
+	for i := range s.Bodies {
+		diff := tree[i].Sub(direct[i]).Length()
+		if size := direct[i].Length(); size > 0 {
+			report.Errors[i] = diff / size
+		} else {
+			report.Errors[i] = diff
+		}
+	}
+
+	sorted := append([]float64(nil), report.Errors...)
+	sort.Float64s(sorted)
+	report.Median = percentile(sorted, 50)
+	report.P99 = percentile(sorted, 99)
+	report.Max = percentile(sorted, 100)
+
+	return report
+}
+
+// percentile returns the p-th percentile of the sorted
+// values using the nearest rank, 0 if there are none.
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
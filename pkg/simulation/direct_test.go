@@ -0,0 +1,28 @@
+package simulation
+
+import "testing"
+
+func TestForceAccuracy(t *testing.T) {
+	bodies := cloud(100, 3)
+
+	// Opening every cell is direct summation
+	exact := NewSimulation(1, 0, bodies...)
+	exact.Softening = 0.01
+	if report := exact.ForceAccuracy(); report.Max > 1e-9 {
+		t.Fatalf("expected no error with theta 0, got %g", report.Max)
+	}
+
+	sim := NewSimulation(1, 0.7, bodies...)
+	sim.Softening = 0.01
+	report := sim.ForceAccuracy()
+
+	if len(report.Errors) != len(bodies) {
+		t.Fatalf("expected %d errors, got %d", len(bodies), len(report.Errors))
+	}
+	if report.Median <= 0 || report.Median > report.P99 || report.P99 > report.Max {
+		t.Fatalf("unexpected percentiles median %g p99 %g max %g", report.Median, report.P99, report.Max)
+	}
+	if report.Theta != sim.Theta {
+		t.Fatalf("expected theta %g in the report, got %g", sim.Theta, report.Theta)
+	}
+}
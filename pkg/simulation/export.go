@@ -0,0 +1,205 @@
+package simulation
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	// treeFormatVersion is the version of the binary form
+	// written by Octree.MarshalBinary.
+	treeFormatVersion = 1
+)
+
+// treeMagic starts the binary form of an oct tree.
+var treeMagic = [4]byte{'O', 'C', 'T', 'R'}
+
+// TreeNode describes a node of an oct tree for export.
+type TreeNode struct {
+	// Level is the depth of the node, 0 for the root.
+	Level int `json:"level"`
+	// Min is the lowest corner of the node's bounds and
+	// Size their length along each axis.
+	Min  Vector `json:"min"`
+	Size Vector `json:"size"`
+	// Mass is the total mass of the bodies in the node and
+	// CenterOfMass their center of mass.
+	Mass         float64 `json:"mass"`
+	CenterOfMass Vector  `json:"centerOfMass"`
+	// Bodies is the number of bodies in the node.
+	Bodies int `json:"bodies"`
+	// Children holds the indices of the node's children in
+	// the exported nodes, none for a leaf.
+	Children []int `json:"children,omitempty"`
+}
+
+// TreeStats summarises the shape of an oct tree.
+type TreeStats struct {
+	// Nodes and Leaves are the number of nodes and how many
+	// of them are leaves.
+	Nodes  int `json:"nodes"`
+	Leaves int `json:"leaves"`
+	// MaxDepth is the depth of the deepest leaf and
+	// MeanDepth the average depth of the leaves.
+	MaxDepth  int     `json:"maxDepth"`
+	MeanDepth float64 `json:"meanDepth"`
+	// MeanOccupancy is the average number of bodies in a
+	// leaf.
+	MeanOccupancy float64 `json:"meanOccupancy"`
+	// Imbalance is MaxDepth divided by MeanDepth, 1 when
+	// every leaf is at the same depth.
+	Imbalance float64 `json:"imbalance"`
+}
+
+// Export returns every node of the oct tree, the root first.
+func (t *Octree) Export() []TreeNode {
+	nodes := make([]TreeNode, len(t.nodes))
+	for i := range t.nodes {
+		n := &t.nodes[i]
+		nodes[i] = TreeNode{
+			Level:        int(n.level),
+			Min:          Vector{n.x, n.y, n.z},
+			Size:         Vector{n.dx, n.dy, n.dz},
+			Mass:         n.mass,
+			CenterOfMass: Vector{n.cmx, n.cmy, n.cmz},
+			Bodies:       int(n.count),
+		}
+		for c := n.child; c < n.child+int32(n.children); c++ {
+			nodes[i].Children = append(nodes[i].Children, int(c))
+		}
+	}
+	return nodes
+}
+
+// Stats returns statistics about the shape of the oct tree.
+func (t *Octree) Stats() TreeStats {
+	stats := TreeStats{Nodes: len(t.nodes)}
+
+	var depth, bodies int
+	for i := range t.nodes {
+		n := &t.nodes[i]
+		if n.children > 0 {
+			continue
+		}
+
+		stats.Leaves++
+		depth += int(n.level)
+		bodies += int(n.count)
+		if int(n.level) > stats.MaxDepth {
+			stats.MaxDepth = int(n.level)
+		}
+	}
+
+	if stats.Leaves > 0 {
+		stats.MeanDepth = float64(depth) / float64(stats.Leaves)
+		stats.MeanOccupancy = float64(bodies) / float64(stats.Leaves)
+	}
+	if stats.MeanDepth > 0 {
+		stats.Imbalance = float64(stats.MaxDepth) / stats.MeanDepth
+	} else {
+		stats.Imbalance = 1
+	}
+	return stats
+}
+
+// MarshalBinary returns the nodes of the oct tree in a compact
+// little endian binary form. It starts with the bytes "OCTR", a
+// version byte and the number of nodes as a uint32. Each node
+// follows in the order of Export as its level (uint8), number
+// of bodies (uint32), number of children (uint8), index of its
+// first child (int32, -1 for a leaf) and then the float64s
+// Min, Size, Mass and CenterOfMass. The children of a node are
+// always next to each other.
+func (t *Octree) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Write(treeMagic[:])
+	buf.WriteByte(treeFormatVersion)
+	binary.Write(&buf, binary.LittleEndian, uint32(len(t.nodes)))
+
+	for i := range t.nodes {
+		n := &t.nodes[i]
+		child := int32(-1)
+		if n.children > 0 {
+			child = n.child
+		}
+
+		binary.Write(&buf, binary.LittleEndian, n.level)
+		binary.Write(&buf, binary.LittleEndian, uint32(n.count))
+		binary.Write(&buf, binary.LittleEndian, n.children)
+		binary.Write(&buf, binary.LittleEndian, child)
+		binary.Write(&buf, binary.LittleEndian, [10]float64{
+			n.x, n.y, n.z,
+			n.dx, n.dy, n.dz,
+			n.mass,
+			n.cmx, n.cmy, n.cmz,
+		})
+	}
+	return buf.Bytes(), nil
+}
+
+// ReadTreeNodes reads the nodes of an oct tree from the binary
+// form written by Octree.MarshalBinary.
+func ReadTreeNodes(data []byte) ([]TreeNode, error) {
+	r := bytes.NewReader(data)
+
+	var magic [4]byte
+	var version uint8
+	var count uint32
+	if err := binary.Read(r, binary.LittleEndian, &magic); err != nil || magic != treeMagic {
+		return nil, fmt.Errorf("the data is not an oct tree")
+	}
+	if err := binary.Read(r, binary.LittleEndian, &version); err != nil {
+		return nil, err
+	}
+	if version != treeFormatVersion {
+		return nil, fmt.Errorf("unsupported oct tree format version %d", version)
+	}
+	if err := binary.Read(r, binary.LittleEndian, &count); err != nil {
+		return nil, err
+	}
+
+	// Each node takes 90 bytes, which stops a bad count
+	// from allocating too much
+	if int64(count)*90 > int64(r.Len()) {
+		return nil, fmt.Errorf("the oct tree data is truncated")
+	}
+
+	nodes := make([]TreeNode, count)
+	for i := range nodes {
+		var record struct {
+			Level    uint8
+			Bodies   uint32
+			Children uint8
+			Child    int32
+			Values   [10]float64
+		}
+		if err := binary.Read(r, binary.LittleEndian, &record); err != nil {
+			return nil, err
+		}
+
+		v := record.Values
+		nodes[i] = TreeNode{
+			Level:        int(record.Level),
+			Min:          Vector{v[0], v[1], v[2]},
+			Size:         Vector{v[3], v[4], v[5]},
+			Mass:         v[6],
+			CenterOfMass: Vector{v[7], v[8], v[9]},
+			Bodies:       int(record.Bodies),
+		}
+		for c := 0; c < int(record.Children); c++ {
+			child := int(record.Child) + c
+			if record.Child < 0 || child >= int(count) {
+				return nil, fmt.Errorf("node %d has a child outside of the tree", i)
+			}
+			nodes[i].Children = append(nodes[i].Children, child)
+		}
+	}
+	return nodes, nil
+}
+
+// Tree builds an oct tree of the simulation's bodies with the
+// simulation's tree options.
+func (s *Simulation) Tree() *Octree {
+	return NewOctree(s.Bodies, s.treeOptions())
+}
@@ -0,0 +1,210 @@
+package simulation
+
+import "math"
+
+// ForceOptions holds the parameters used to calculate the
+// gravitational forces between the bodies in an oct tree.
+type ForceOptions struct {
+	// Grav is the gravitational constant.
+	Grav float64
+	// MAC decides which cells are far enough from a body
+	// to be treated as a single mass. When it is nil every
+	// cell is opened, as with a GeometricMAC of Theta 0.
+	MAC MAC
+	// Order is the highest multipole moment used for cells
+	// treated as a single mass.
+	Order int
+	// Previous holds the accelerations of the bodies from
+	// the last step for criteria which need them, it may
+	// be nil.
+	Previous []Vector
+	// Softening is the softening length and Kernel the
+	// name of the softening kernel applied with it.
+	Softening float64
+	Kernel    string
+	// Workers is the number of goroutines the bodies are
+	// shared between, all of the processors when it is 0.
+	Workers int
+}
+
+// Accelerations returns the acceleration of each body in the
+// oct tree caused by the gravity of the others, in the same
+// order as the bodies the tree was built from. The bodies are
+// shared between opts.Workers goroutines in runs of their sorted
+// order, so each worker walks nearby bodies one after another.
+func (t *Octree) Accelerations(opts ForceOptions) []Vector {
+	acc := make([]Vector, len(t.bodies))
+
+	parallel(opts.Workers, len(t.index), func(start, end int) {
+		var stack []int32
+		for s := start; s < end; s++ {
+			acc[t.index[s]], stack = t.treeForce(s, opts, stack)
+		}
+	})
+	return acc
+}
+
+// AccelerationsOn returns the acceleration of only the bodies
+// at the given positions in the slice the tree was built from,
+// in the same order as the indices.
+func (t *Octree) AccelerationsOn(indices []int, opts ForceOptions) []Vector {
+	acc := make([]Vector, len(indices))
+
+	parallel(opts.Workers, len(indices), func(start, end int) {
+		var stack []int32
+		for k := start; k < end; k++ {
+			acc[k], stack = t.treeForce(t.sorted[indices[k]], opts, stack)
+		}
+	})
+	return acc
+}
+
+// treeForce calculates the acceleration of the sorted body s
+// caused by the rest of the tree. The stack is used for the
+// walk and returned so it can be reused.
+func (t *Octree) treeForce(s int, opts ForceOptions, stack []int32) (Vector, []int32) {
+	// a = G * mcm *
+	//         xcm - x       ycm - y         zcm - z
+	//       ( ---------- , ---------- , ---------- )
+	//            r3            r3            r3
+	//
+	// with 1/r3 replaced by the softening kernel
+	var a Vector
+	p := t.pos[s]
+
+	stack = t.walk(s, opts, stack, func(j int32) {
+		d := t.pos[j].Sub(p)
+		g := opts.Grav * t.mass[j] * softenedForce(opts.Kernel, d.Length(), opts.Softening)
+		a = a.Add(d.Scale(g))
+	}, func(n *OctNode) {
+		d := Vector{n.cmx - p.X, n.cmy - p.Y, n.cmz - p.Z}
+		g := opts.Grav * n.mass * softenedForce(opts.Kernel, d.Length(), opts.Softening)
+		a = a.Add(d.Scale(g))
+
+		if opts.Order >= OrderQuadrupole {
+			m := multipoleAccel(&n.quad, &n.oct, opts.Order, d.Scale(-1))
+			a = a.Add(m.Scale(opts.Grav))
+		}
+	})
+
+	return a, stack
+}
+
+// Potential returns the total gravitational potential energy
+// of the bodies in the oct tree, approximating distant nodes in
+// the same way as Accelerations.
+func (t *Octree) Potential(opts ForceOptions) float64 {
+	phi := make([]float64, len(t.index))
+
+	parallel(opts.Workers, len(t.index), func(start, end int) {
+		var stack []int32
+		for s := start; s < end; s++ {
+			phi[s], stack = t.treePotential(s, opts, stack)
+		}
+	})
+
+	// Sum in order so the result does not depend on the
+	// number of workers
+	var potential float64
+	for s := range phi {
+		potential += t.mass[s] * phi[s]
+	}
+
+	// Every pair of bodies has been counted twice
+	return potential / 2
+}
+
+// treePotential calculates the gravitational potential at the
+// sorted body s caused by the rest of the tree.
+func (t *Octree) treePotential(s int, opts ForceOptions, stack []int32) (float64, []int32) {
+	var phi float64
+	p := t.pos[s]
+
+	stack = t.walk(s, opts, stack, func(j int32) {
+		r := t.pos[j].Sub(p).Length()
+		phi -= opts.Grav * t.mass[j] * softenedPotential(opts.Kernel, r, opts.Softening)
+	}, func(n *OctNode) {
+		d := Vector{n.cmx - p.X, n.cmy - p.Y, n.cmz - p.Z}
+		phi -= opts.Grav * n.mass * softenedPotential(opts.Kernel, d.Length(), opts.Softening)
+
+		if opts.Order >= OrderQuadrupole {
+			phi += opts.Grav * multipolePotential(&n.quad, &n.oct, opts.Order, d.Scale(-1))
+		}
+	})
+
+	return phi, stack
+}
+
+// walk visits the tree from the point of view of the sorted
+// body s. Nodes the MAC accepts are passed to cell, while the
+// bodies of any leaf which is too close, or holds one body, are
+// passed one at a time to body, leaving out s itself.
+func (t *Octree) walk(s int, opts ForceOptions, stack []int32, body func(j int32), cell func(n *OctNode)) []int32 {
+	stack = append(stack[:0], 0)
+	for len(stack) > 0 {
+		n := &t.nodes[stack[len(stack)-1]]
+		stack = stack[:len(stack)-1]
+
+		// Empty nodes do not exert any force
+		if n.count == 0 {
+			continue
+		}
+
+		// A leaf holding more than one body can be treated
+		// as a single mass like any other node
+		if (n.children > 0 || n.count > 1) && t.accept(n, s, opts) {
+			cell(n)
+			continue
+		}
+
+		if n.children == 0 {
+			for j := n.first; j < n.first+n.count; j++ {
+				// Do not calculate the force on its self
+				if int(j) != s {
+					body(j)
+				}
+			}
+			continue
+		}
+
+		for c := n.child; c < n.child+int32(n.children); c++ {
+			stack = append(stack, c)
+		}
+	}
+	return stack
+}
+
+// accept reports whether the node is far enough from the
+// sorted body s to be treated as a single mass.
+func (t *Octree) accept(n *OctNode, s int, opts ForceOptions) bool {
+	// A node containing the body always has to be opened
+	if int(n.first) <= s && s < int(n.first+n.count) {
+		return false
+	}
+
+	p := t.pos[s]
+	dx := n.cmx - p.X
+	dy := n.cmy - p.Y
+	dz := n.cmz - p.Z
+	r := math.Sqrt(dx*dx + dy*dy + dz*dz)
+
+	// The side length of the node
+	size := math.Max(n.dx, math.Max(n.dy, n.dz))
+
+	// The furthest corner of the node from its
+	// centre of mass
+	bx := math.Max(n.cmx-n.x, n.x+n.dx-n.cmx)
+	by := math.Max(n.cmy-n.y, n.y+n.dy-n.cmy)
+	bz := math.Max(n.cmz-n.z, n.z+n.dz-n.cmz)
+	bmax := math.Sqrt(bx*bx + by*by + bz*bz)
+
+	var aold float64
+	if i := t.index[s]; i < len(opts.Previous) {
+		aold = opts.Previous[i].Length()
+	}
+
+	if opts.MAC == nil {
+		return false
+	}
+	return opts.MAC.Accept(size, bmax, n.mass, r, aold)
+}
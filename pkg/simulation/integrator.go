@@ -0,0 +1,172 @@
+package simulation
+
+import "fmt"
+
+const (
+	// IntegratorEuler is the name of the SemiImplicitEuler
+	// integrator.
+	IntegratorEuler = "euler"
+	// IntegratorLeapfrog is the name of the Leapfrog
+	// integrator.
+	IntegratorLeapfrog = "leapfrog"
+	// IntegratorVerlet is the name of the VelocityVerlet
+	// integrator.
+	IntegratorVerlet = "verlet"
+	// IntegratorRK4 is the name of the RK4 integrator.
+	IntegratorRK4 = "rk4"
+
+	// DefaultIntegrator is the integrator used by a new
+	// Simulation.
+	DefaultIntegrator = IntegratorLeapfrog
+)
+
+// AccelerationFunc returns the acceleration of each body
+// caused by the gravity of all of the other bodies.
+type AccelerationFunc func(bodies []Body) []Vector
+
+// Integrator advances the bodies of a simulation through
+// time.
+type Integrator interface {
+	// Integrate moves the bodies forward in time by dt.
+	// acc holds the acceleration of each body at the start
+	// of the step and accel is used to find any others
+	// that are needed. The accelerations at the end of the
+	// step are returned if they were calculated, otherwise
+	// nil is returned.
+	Integrate(bodies []Body, acc []Vector, dt float64, accel AccelerationFunc) []Vector
+}
+
+// NewIntegrator returns the Integrator with the name
+// given, an empty name returns the DefaultIntegrator.
+func NewIntegrator(name string) (Integrator, error) {
+	switch name {
+	case "":
+		return NewIntegrator(DefaultIntegrator)
+	case IntegratorEuler:
+		return SemiImplicitEuler{}, nil
+	case IntegratorLeapfrog:
+		return Leapfrog{}, nil
+	case IntegratorVerlet:
+		return VelocityVerlet{}, nil
+	case IntegratorRK4:
+		return RK4{}, nil
+	}
+	return nil, fmt.Errorf("unknown integrator %q", name)
+}
+
+// SemiImplicitEuler is a first order symplectic integrator
+// which updates the velocity of each body and then moves it
+// with its new velocity.
+type SemiImplicitEuler struct{}
+
+// Integrate performs a single semi-implicit Euler step.
+func (SemiImplicitEuler) Integrate(bodies []Body, acc []Vector, dt float64, accel AccelerationFunc) []Vector {
+	for i := range bodies {
+		bodies[i].kick(acc[i], dt)
+		bodies[i].drift(dt)
+	}
+	return nil
+}
+
+// Leapfrog is the second order symplectic kick-drift-kick
+// leapfrog integrator. Energy errors stay bounded over long
+// runs with a fixed timestep.
+type Leapfrog struct{}
+
+// Integrate performs a single kick-drift-kick step.
+func (Leapfrog) Integrate(bodies []Body, acc []Vector, dt float64, accel AccelerationFunc) []Vector {
+	// Kick by half a step and then drift the
+	// whole step
+	for i := range bodies {
+		bodies[i].kick(acc[i], dt/2)
+		bodies[i].drift(dt)
+	}
+
+	// Kick by the remaining half step using the
+	// accelerations at the new positions
+	acc = accel(bodies)
+	for i := range bodies {
+		bodies[i].kick(acc[i], dt/2)
+	}
+
+	return acc
+}
+
+// VelocityVerlet is the second order symplectic velocity
+// Verlet integrator.
+type VelocityVerlet struct{}
+
+// Integrate performs a single velocity Verlet step.
+func (VelocityVerlet) Integrate(bodies []Body, acc []Vector, dt float64, accel AccelerationFunc) []Vector {
+	// x(t+dt) = x(t) + v(t)dt + a(t)dt^2/2
+	for i := range bodies {
+		bodies[i].X += bodies[i].VX*dt + acc[i].X*dt*dt/2
+		bodies[i].Y += bodies[i].VY*dt + acc[i].Y*dt*dt/2
+		bodies[i].Z += bodies[i].VZ*dt + acc[i].Z*dt*dt/2
+	}
+
+	// v(t+dt) = v(t) + (a(t) + a(t+dt))dt/2
+	next := accel(bodies)
+	for i := range bodies {
+		bodies[i].kick(acc[i].Add(next[i]), dt/2)
+	}
+
+	return next
+}
+
+// RK4 is the classic fourth order Runge-Kutta integrator.
+// It is not symplectic, so energy drifts over long runs, but
+// it is very accurate over short ones.
+type RK4 struct{}
+
+// Integrate performs a single fourth order Runge-Kutta step.
+func (RK4) Integrate(bodies []Body, acc []Vector, dt float64, accel AccelerationFunc) []Vector {
+	start := make([]Body, len(bodies))
+	copy(start, bodies)
+
+	// stage returns the bodies advanced from the start
+	// of the step by h using the derivatives of the
+	// previous stage.
+	stage := make([]Body, len(bodies))
+	advance := func(vel, a []Vector, h float64) []Body {
+		for i := range start {
+			stage[i] = start[i]
+			stage[i].X += vel[i].X * h
+			stage[i].Y += vel[i].Y * h
+			stage[i].Z += vel[i].Z * h
+			stage[i].kick(a[i], h)
+		}
+		return stage
+	}
+	velocities := func(bodies []Body) []Vector {
+		vel := make([]Vector, len(bodies))
+		for i := range bodies {
+			vel[i] = Vector{bodies[i].VX, bodies[i].VY, bodies[i].VZ}
+		}
+		return vel
+	}
+
+	// k1 is the derivative at the start of the step
+	v1, a1 := velocities(start), acc
+	// k2 and k3 are derivatives at the midpoint
+	s := advance(v1, a1, dt/2)
+	v2, a2 := velocities(s), accel(s)
+	s = advance(v2, a2, dt/2)
+	v3, a3 := velocities(s), accel(s)
+	// k4 is the derivative at the end of the step
+	s = advance(v3, a3, dt)
+	v4, a4 := velocities(s), accel(s)
+
+	// Combine the weighted derivatives
+	for i := range bodies {
+		bodies[i] = start[i]
+		bodies[i].X += dt / 6 * (v1[i].X + 2*v2[i].X + 2*v3[i].X + v4[i].X)
+		bodies[i].Y += dt / 6 * (v1[i].Y + 2*v2[i].Y + 2*v3[i].Y + v4[i].Y)
+		bodies[i].Z += dt / 6 * (v1[i].Z + 2*v2[i].Z + 2*v3[i].Z + v4[i].Z)
+		bodies[i].VX += dt / 6 * (a1[i].X + 2*a2[i].X + 2*a3[i].X + a4[i].X)
+		bodies[i].VY += dt / 6 * (a1[i].Y + 2*a2[i].Y + 2*a3[i].Y + a4[i].Y)
+		bodies[i].VZ += dt / 6 * (a1[i].Z + 2*a2[i].Z + 2*a3[i].Z + a4[i].Z)
+	}
+
+	return nil
+}
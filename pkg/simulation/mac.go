@@ -0,0 +1,102 @@
+package simulation
+
+import "fmt"
+
+const (
+	// MACGeometric is the classic Barnes-Hut criterion,
+	// a cell is accepted when s/d < theta for a cell of side
+	// s at a distance d.
+	MACGeometric = "geometric"
+	// MACBmax is the criterion of Salmon & Warren, a cell is
+	// accepted when d > bmax/theta, bmax being the furthest
+	// any point of the cell is from its centre of mass.
+	MACBmax = "bmax"
+	// MACRelative is the relative criterion used by GADGET,
+	// a cell is accepted when the size of the error it would
+	// cause is a small fraction alpha of the body's
+	// acceleration.
+	MACRelative = "relative"
+
+	// DefaultMAC is the criterion used by a new Simulation.
+	DefaultMAC = MACGeometric
+)
+
+// MAC is a multipole acceptance criterion. It decides if a
+// cell of the oct tree is far enough away from a body for
+// the cell's mass to be used instead of opening it and
+// visiting its children.
+type MAC interface {
+	// Accept reports whether a cell may be used without
+	// opening it. size is the side length of the cell, bmax
+	// the furthest any point of the cell is from its centre
+	// of mass, mass its mass and d the distance from the
+	// body to its centre of mass. aold is the size of the
+	// body's acceleration from the last step, 0 if unknown.
+	Accept(size, bmax, mass, d, aold float64) bool
+}
+
+// NewMAC returns the criterion with the name given, an
+// empty name returns the DefaultMAC.
+func NewMAC(name string, theta, alpha, grav float64) (MAC, error) {
+	switch name {
+	case "":
+		return NewMAC(DefaultMAC, theta, alpha, grav)
+	case MACGeometric:
+		return GeometricMAC{Theta: theta}, nil
+	case MACBmax:
+		return BmaxMAC{Theta: theta}, nil
+	case MACRelative:
+		if alpha <= 0 {
+			return nil, fmt.Errorf("the relative criterion needs a strictly positive alpha")
+		}
+		return RelativeMAC{Grav: grav, Alpha: alpha, Theta: theta}, nil
+	}
+	return nil, fmt.Errorf("unknown opening criterion %q", name)
+}
+
+// GeometricMAC accepts cells that appear smaller than the
+// angle Theta from the body.
+type GeometricMAC struct {
+	Theta float64
+}
+
+// Accept reports whether s/d < theta.
+func (m GeometricMAC) Accept(size, bmax, mass, d, aold float64) bool {
+	return size < m.Theta*d
+}
+
+// BmaxMAC accepts cells whose contents all lie within the
+// angle Theta of their centre of mass as seen from the body.
+// Unlike GeometricMAC it is not fooled by cells whose mass
+// sits in a corner.
+type BmaxMAC struct {
+	Theta float64
+}
+
+// Accept reports whether d > bmax/theta.
+func (m BmaxMAC) Accept(size, bmax, mass, d, aold float64) bool {
+	return bmax < m.Theta*d
+}
+
+// RelativeMAC accepts cells when the estimated error of
+// treating them as a single mass,
+//
+//	G * M * s^2 / d^4
+//
+// is less than Alpha times the body's acceleration. Bodies
+// without a previous acceleration fall back to the geometric
+// criterion with Theta.
+type RelativeMAC struct {
+	Grav  float64
+	Alpha float64
+	Theta float64
+}
+
+// Accept reports whether the error estimate of the cell is
+// within alpha of the body's acceleration.
+func (m RelativeMAC) Accept(size, bmax, mass, d, aold float64) bool {
+	if aold <= 0 {
+		return size < m.Theta*d
+	}
+	return m.Grav*mass*size*size < m.Alpha*aold*d*d*d*d
+}
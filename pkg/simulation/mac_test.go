@@ -0,0 +1,54 @@
+package simulation
+
+import (
+	"math"
+	"testing"
+)
+
+// cloud returns n bodies of unit mass placed at random
+// in a unit cube.
+func cloud(n int, seed int64) []Body {
+	r := newRNG(seed)
+	bodies := make([]Body, n)
+	for i := range bodies {
+		bodies[i] = Body{
+			X: r.float64(), Y: r.float64(), Z: r.float64(),
+			Radius: 0.01, Density: unitMassDensity,
+		}
+	}
+	return bodies
+}
+
+// maxRelativeError returns the largest relative difference
+// between the accelerations and the exact ones.
+func maxRelativeError(acc, exact []Vector) float64 {
+	worst := 0.0
+	for i := range acc {
+		worst = math.Max(worst, acc[i].Sub(exact[i]).Length()/exact[i].Length())
+	}
+	return worst
+}
+
+func TestOpeningCriteria(t *testing.T) {
+	bodies := cloud(200, 1)
+
+	exact := NewSimulation(1, 0, bodies...)
+	exactAcc := exact.accelerations(exact.Bodies)
+
+	for _, opening := range []string{MACGeometric, MACBmax, MACRelative} {
+		sim := NewSimulation(1, 0.5, bodies...)
+		sim.Opening = opening
+		sim.Alpha = 0.001
+		// The relative criterion needs the accelerations
+		// from a previous step
+		sim.acc = exactAcc
+
+		if err := sim.Validate(); err != nil {
+			t.Fatal(err)
+		}
+
+		if worst := maxRelativeError(sim.accelerations(sim.Bodies), exactAcc); worst > 0.1 {
+			t.Fatalf("%s: relative force error %f is too large", opening, worst)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package simulation
+
+import (
+	"fmt"
+	"math"
+)
+
+const (
+	// OrderMonopole treats accepted cells as a point mass at
+	// their centre of mass. The dipole moment about the
+	// centre of mass is zero so this is also first order.
+	OrderMonopole = 0
+	// OrderQuadrupole adds the quadrupole moment of accepted
+	// cells.
+	OrderQuadrupole = 2
+	// OrderOctupole adds the quadrupole and octupole moments
+	// of accepted cells.
+	OrderOctupole = 3
+)
+
+// validateOrder returns an error if the expansion order is
+// not supported.
+func validateOrder(order int) error {
+	if order < OrderMonopole || order > OrderOctupole {
+		return fmt.Errorf("the multipole order must be between %d and %d", OrderMonopole, OrderOctupole)
+	}
+	return nil
+}
+
+// quadrupole is the symmetric second moment sum(m x_i x_j)
+// of the mass in a cell about its centre of mass, stored as
+// xx, xy, xz, yy, yz, zz.
+type quadrupole [6]float64
+
+// octupole is the symmetric third moment sum(m x_i x_j x_k)
+// of the mass in a cell about its centre of mass, stored as
+// xxx, xxy, xxz, xyy, xyz, xzz, yyy, yyz, yzz, zzz.
+type octupole [10]float64
+
+// addShifted adds the moments q and o of a child with mass m
+// whose centre of mass is d from the parent's centre of mass.
+// The child's moments are about its own centre of mass, so
+// the parallel axis theorem moves them to the parent's.
+func addShifted(pq *quadrupole, po *octupole, q quadrupole, o octupole, m float64, d Vector) {
+	// M2_ij += m d_i d_j
+	pq[0] += q[0] + m*d.X*d.X
+	pq[1] += q[1] + m*d.X*d.Y
+	pq[2] += q[2] + m*d.X*d.Z
+	pq[3] += q[3] + m*d.Y*d.Y
+	pq[4] += q[4] + m*d.Y*d.Z
+	pq[5] += q[5] + m*d.Z*d.Z
+
+	// M3_ijk += d_i M2_jk + d_j M2_ik + d_k M2_ij + m d_i d_j d_k
+	po[0] += o[0] + 3*d.X*q[0] + m*d.X*d.X*d.X
+	po[1] += o[1] + 2*d.X*q[1] + d.Y*q[0] + m*d.X*d.X*d.Y
+	po[2] += o[2] + 2*d.X*q[2] + d.Z*q[0] + m*d.X*d.X*d.Z
+	po[3] += o[3] + d.X*q[3] + 2*d.Y*q[1] + m*d.X*d.Y*d.Y
+	po[4] += o[4] + d.X*q[4] + d.Y*q[2] + d.Z*q[1] + m*d.X*d.Y*d.Z
+	po[5] += o[5] + d.X*q[5] + 2*d.Z*q[2] + m*d.X*d.Z*d.Z
+	po[6] += o[6] + 3*d.Y*q[3] + m*d.Y*d.Y*d.Y
+	po[7] += o[7] + 2*d.Y*q[4] + d.Z*q[3] + m*d.Y*d.Y*d.Z
+	po[8] += o[8] + d.Y*q[5] + 2*d.Z*q[4] + m*d.Y*d.Z*d.Z
+	po[9] += o[9] + 3*d.Z*q[5] + m*d.Z*d.Z*d.Z
+}
+
+// apply returns the quadrupole tensor multiplied by v.
+func (q *quadrupole) apply(v Vector) Vector {
+	return Vector{
+		q[0]*v.X + q[1]*v.Y + q[2]*v.Z,
+		q[1]*v.X + q[3]*v.Y + q[4]*v.Z,
+		q[2]*v.X + q[4]*v.Y + q[5]*v.Z,
+	}
+}
+
+// trace returns the trace of the quadrupole tensor.
+func (q *quadrupole) trace() float64 {
+	return q[0] + q[3] + q[5]
+}
+
+// apply2 returns the octupole tensor contracted twice with
+// v, the vector sum(M3_ijk v_j v_k).
+func (o *octupole) apply2(v Vector) Vector {
+	return Vector{
+		o[0]*v.X*v.X + o[3]*v.Y*v.Y + o[5]*v.Z*v.Z + 2*(o[1]*v.X*v.Y+o[2]*v.X*v.Z+o[4]*v.Y*v.Z),
+		o[1]*v.X*v.X + o[6]*v.Y*v.Y + o[8]*v.Z*v.Z + 2*(o[3]*v.X*v.Y+o[4]*v.X*v.Z+o[7]*v.Y*v.Z),
+		o[2]*v.X*v.X + o[7]*v.Y*v.Y + o[9]*v.Z*v.Z + 2*(o[4]*v.X*v.Y+o[5]*v.X*v.Z+o[8]*v.Y*v.Z),
+	}
+}
+
+// trace returns the vector sum(M3_ijj).
+func (o *octupole) trace() Vector {
+	return Vector{
+		o[0] + o[3] + o[5],
+		o[1] + o[6] + o[8],
+		o[2] + o[7] + o[9],
+	}
+}
+
+// multipoleAccel returns the acceleration, divided by the
+// gravitational constant, caused by the quadrupole and, for
+// the octupole order, octupole moments of a cell at a
+// position r from the cell's centre of mass.
+//
+// It is the gradient of the terms of the expansion
+//
+//	phi = -G * ( M/r + 1/2 M2_ij d_ij(1/r) - 1/6 M3_ijk d_ijk(1/r) )
+func multipoleAccel(q *quadrupole, o *octupole, order int, r Vector) Vector {
+	var acc Vector
+	if order < OrderQuadrupole {
+		return acc
+	}
+
+	r2 := r.Dot(r)
+	inv2 := 1 / r2
+	inv7 := inv2 * inv2 * inv2 / math.Sqrt(r2)
+
+	// a = -1/2 (15 (r.M2.r) r - 6 r^2 M2.r - 3 r^2 tr(M2) r) / r^7
+	qr := q.apply(r)
+	rqr := r.Dot(qr)
+	acc = r.Scale(15*rqr - 3*r2*q.trace()).Sub(qr.Scale(6 * r2)).Scale(-0.5 * inv7)
+
+	if order < OrderOctupole {
+		return acc
+	}
+
+	// a = -1/6 (105 (M3:rrr) r - 45 r^2 M3:rr - 45 r^2 (t.r) r + 9 r^4 t) / r^9
+	orr := o.apply2(r)
+	orrr := orr.Dot(r)
+	t := o.trace()
+	tr := t.Dot(r)
+	oct := r.Scale(105*orrr - 45*r2*tr).Sub(orr.Scale(45 * r2)).Add(t.Scale(9 * r2 * r2))
+
+	return acc.Add(oct.Scale(-inv7 * inv2 / 6))
+}
+
+// multipolePotential returns the potential, divided by the
+// gravitational constant, of the quadrupole and, for the
+// octupole order, octupole moments of a cell at a position r
+// from the cell's centre of mass.
+func multipolePotential(q *quadrupole, o *octupole, order int, r Vector) float64 {
+	if order < OrderQuadrupole {
+		return 0
+	}
+
+	r2 := r.Dot(r)
+	inv5 := 1 / (r2 * r2 * math.Sqrt(r2))
+
+	// phi = -1/2 (3 (r.M2.r) - r^2 tr(M2)) / r^5
+	phi := -0.5 * (3*r.Dot(q.apply(r)) - r2*q.trace()) * inv5
+
+	if order < OrderOctupole {
+		return phi
+	}
+
+	// phi = -1/6 (15 M3:rrr - 9 r^2 (t.r)) / r^7
+	return phi - (15*o.apply2(r).Dot(r)-9*r2*o.trace().Dot(r))*inv5/(6*r2)
+}
@@ -0,0 +1,33 @@
+package simulation
+
+import "testing"
+
+// meanRelativeError returns the average relative difference
+// between the accelerations and the exact ones.
+func meanRelativeError(acc, exact []Vector) float64 {
+	total := 0.0
+	for i := range acc {
+		total += acc[i].Sub(exact[i]).Length() / exact[i].Length()
+	}
+	return total / float64(len(acc))
+}
+
+func TestMultipoleOrders(t *testing.T) {
+	bodies := cloud(300, 2)
+
+	exact := NewSimulation(1, 0, bodies...)
+	exactAcc := exact.accelerations(exact.Bodies)
+
+	previous := 1.0
+	for _, order := range []int{OrderMonopole, OrderQuadrupole, OrderOctupole} {
+		sim := NewSimulation(1, 0.8, bodies...)
+		sim.Order = order
+
+		err := meanRelativeError(sim.accelerations(sim.Bodies), exactAcc)
+		if err >= previous {
+			t.Fatalf("order %d: error %g did not improve on %g", order, err, previous)
+		}
+		t.Logf("order %d: mean relative error %g", order, err)
+		previous = err
+	}
+}
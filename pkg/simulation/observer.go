@@ -0,0 +1,67 @@
+package simulation
+
+// Logger records messages about a running simulation. A
+// *log.Logger from the standard library is a Logger.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// Observer holds functions called as a simulation runs, any of
+// which may be nil. They are called on the goroutine running
+// the simulation, which waits for them to return.
+type Observer struct {
+	// BeforeStep is called before each step is taken, with
+	// Step still holding the number of the last step.
+	BeforeStep func(s *Simulation)
+	// AfterStep is called once each step, including its
+	// collisions and diagnostics, has finished.
+	AfterStep func(s *Simulation)
+	// OnEvent is called for each event, such as a collision,
+	// as it happens.
+	OnEvent func(s *Simulation, e Event)
+}
+
+// AddObserver registers an observer to be called as the
+// simulation runs.
+func (s *Simulation) AddObserver(o Observer) {
+	s.observers = append(s.observers, o)
+}
+
+// logf writes a message to the simulation's Logger, when it
+// has one.
+func (s *Simulation) logf(format string, v ...interface{}) {
+	if s.Logger != nil {
+		s.Logger.Printf(format, v...)
+	}
+}
+
+// beforeStep calls the BeforeStep function of each observer.
+func (s *Simulation) beforeStep() {
+	for _, o := range s.observers {
+		if o.BeforeStep != nil {
+			o.BeforeStep(s)
+		}
+	}
+}
+
+// afterStep calls the AfterStep function of each observer.
+func (s *Simulation) afterStep() {
+	for _, o := range s.observers {
+		if o.AfterStep != nil {
+			o.AfterStep(s)
+		}
+	}
+}
+
+// event records an event and passes it to the OnEvent
+// function of each observer.
+func (s *Simulation) event(e Event) {
+	s.Events = append(s.Events, e)
+	s.logf("step %d: %s of %v into %v", e.Step, e.Type, e.Bodies, e.Result)
+
+	for _, o := range s.observers {
+		if o.OnEvent != nil {
+			o.OnEvent(s, e)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package simulation
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestObservers(t *testing.T) {
+	sim := NewSimulation(0, 0, headOn()...)
+	sim.Collisions = CollisionMerge
+
+	var out bytes.Buffer
+	sim.Logger = log.New(&out, "", 0)
+
+	var before, after []int
+	var events []Event
+	sim.AddObserver(Observer{
+		BeforeStep: func(s *Simulation) { before = append(before, s.Step) },
+		AfterStep:  func(s *Simulation) { after = append(after, s.Step) },
+	})
+	sim.AddObserver(Observer{
+		OnEvent: func(s *Simulation, e Event) { events = append(events, e) },
+	})
+
+	sim.Steps(3)
+
+	if len(before) != 3 || before[0] != 0 || before[2] != 2 {
+		t.Fatalf("expected BeforeStep to see steps 0 to 2, got %v", before)
+	}
+	if len(after) != 3 || after[0] != 1 || after[2] != 3 {
+		t.Fatalf("expected AfterStep to see steps 1 to 3, got %v", after)
+	}
+	if len(events) != 1 || events[0].Type != CollisionMerge {
+		t.Fatalf("expected a single merge event, got %v", events)
+	}
+
+	if !strings.Contains(out.String(), "step 3:") || !strings.Contains(out.String(), CollisionMerge) {
+		t.Fatalf("expected the steps and the merge to be logged, got %q", out.String())
+	}
+}
@@ -3,426 +3,660 @@ package simulation
 import (
 	"fmt"
 	"math"
+	"sort"
 	"strings"
+	"sync"
 )
 
+const (
+	// mortonBits is the number of bits of each coordinate
+	// in a Morton key, which is also the deepest level of
+	// the oct tree.
+	mortonBits = 21
+
+	// DefaultDepthThreshold is the fraction of the depth of
+	// its leaves a refitted tree can lose before it is rebuilt.
+	DefaultDepthThreshold = 0.1
+)
+
+// Octree is an oct tree stored in flat slices. The bodies
+// are sorted by the Morton (Z-order) key of their position,
+// so the bodies inside any node are a contiguous range of
+// the sorted bodies. The nodes are kept in a single slice and
+// refer to their children by index, with the root first and
+// the children of each node next to each other.
+type Octree struct {
+	// nodes holds every node of the tree
+	nodes []OctNode
+	// bodies is the slice the tree was built from
+	bodies []Body
+	// index holds the position in bodies of each sorted
+	// body and sorted the reverse
+	index  []int
+	sorted []int
+	// keys, pos and mass hold the Morton key, position
+	// and mass of each sorted body
+	keys []uint64
+	pos  []Vector
+	mass []float64
+	// x, y, z is the lowest corner of the root cube and
+	// size is its side length
+	x, y, z, size float64
+	// workers is the number of goroutines the tree is
+	// built with
+	workers int
+	// capacity is the most bodies a leaf holds
+	capacity int32
+	// depthThreshold is the fraction of the depth of the
+	// leaves the tree can lose before it is unbalanced
+	depthThreshold float64
+}
+
+// TreeOptions holds the parameters used to build an oct tree.
+type TreeOptions struct {
+	// Workers is the number of goroutines the tree is built
+	// with, all of the processors when it is 0. The tree is
+	// the same however many workers build it.
+	Workers int
+	// LeafCapacity is the most bodies a leaf node holds
+	// before it is split, 1 when it is 0. The bodies in a
+	// leaf interact with each other directly.
+	LeafCapacity int
+	// DepthThreshold is the fraction of the mean depth of
+	// its leaves a refitted tree can lose, as their bounds
+	// grow to hold the bodies which have left them, before
+	// it needs rebuilding, DefaultDepthThreshold when it is 0.
+	DepthThreshold float64
+}
+
 // OctNode represents a cube in 3D space
 // organised in a tree.
 type OctNode struct {
-	// Body is struct containing the data about each object
-	// in space
-	body Body
-	// children is a slice of nodes that are contained by
-	// the node
-	children []OctNode
-	// parent is a pointer to the node of which this node
-	// is a child to
-	parent *OctNode
-	// empty is true if it contains no body
-	empty bool
 	// xyz is the top corner of the cube
 	x, y, z float64
 	// dx, dy, dz is the length width and depth of the cube
 	dx, dy, dz float64
 	// The center of mass of the cube
 	cmx, cmy, cmz float64
-	// fx. fy, fz is the force that should be applied to
-	// the cube
-	fx, fy, fz float64
-	// mass is the total mass of all its self and children
-	// nodes
+	// mass is the total mass of all the bodies in the node
 	mass float64
+	// quad and oct are the quadrupole and octupole moments
+	// of the mass in the node about its center of mass
+	quad quadrupole
+	oct  octupole
+	// first and count select the bodies in the node from
+	// the tree's sorted bodies
+	first, count int32
+	// child is the index of the node's first child and
+	// children the number of children, 0 for a leaf
+	child    int32
+	children uint8
+	// level is the depth of the node, 0 for the root
+	level uint8
 }
 
-// NewOctNode child node of the parent at a given position
-// the size of the node is half in every direction of the
-// parent node. The new node does not contain any children
-// and is empty when returned.
-func NewOctNode(parent *OctNode, x, y, z float64) OctNode {
-	return OctNode{
-		parent:   parent,
-		empty:    true,
-		x:        x,
-		y:        y,
-		z:        z,
-		dx:       (*parent).dx / 2,
-		dy:       (*parent).dy / 2,
-		dz:       (*parent).dz / 2,
-		children: make([]OctNode, 0),
+// NewOctree builds an oct tree containing the bodies and
+// calculates the mass and moments of each node.
+func NewOctree(bodies []Body, opts TreeOptions) *Octree {
+	t := &Octree{
+		bodies:   bodies,
+		workers:  workerCount(opts.Workers),
+		capacity: int32(opts.LeafCapacity),
 	}
+	if t.capacity <= 0 {
+		t.capacity = 1
+	}
+	t.setDepthThreshold(opts.DepthThreshold)
+
+	t.bound()
+	t.sort()
+
+	// Create the root node and split it until no leaf
+	// holds more bodies than its capacity
+	t.nodes = append(t.nodes, t.newNode(0, 0, 0, 0, 0, int32(len(bodies))))
+	if t.workers > 1 {
+		t.splitParallel()
+	} else {
+		t.nodes = t.split(t.nodes, 0)
+	}
+
+	t.CalcMass()
+	return t
 }
 
-// NewRootNode creates an empty node with a size and position
-// which encompasses all of the bodies provided.
-func NewRootNode(bodies []Body) OctNode {
-	// find the lowest and highest coordinates
+// bound finds the smallest cube which encompasses all of
+// the bodies.
+func (t *Octree) bound() {
+	if len(t.bodies) == 0 {
+		t.size = 1
+		return
+	}
+
+	// find the lowest and highest coordinates, each
+	// worker finding them for its own bodies
+	var mutex sync.Mutex
 	lx, ly, lz := math.MaxFloat64, math.MaxFloat64, math.MaxFloat64
 	hx, hy, hz := -math.MaxFloat64, -math.MaxFloat64, -math.MaxFloat64
-	for i := 0; i < len(bodies); i++ {
-		// Check if the body is in a higher
-		// position
-		if bodies[i].X > hx {
-			hx = bodies[i].X + 1
+	parallel(t.workers, len(t.bodies), func(start, end int) {
+		l := Vector{math.MaxFloat64, math.MaxFloat64, math.MaxFloat64}
+		h := Vector{-math.MaxFloat64, -math.MaxFloat64, -math.MaxFloat64}
+		for i := start; i < end; i++ {
+			h.X = math.Max(h.X, t.bodies[i].X)
+			h.Y = math.Max(h.Y, t.bodies[i].Y)
+			h.Z = math.Max(h.Z, t.bodies[i].Z)
+
+			l.X = math.Min(l.X, t.bodies[i].X)
+			l.Y = math.Min(l.Y, t.bodies[i].Y)
+			l.Z = math.Min(l.Z, t.bodies[i].Z)
 		}
-		if bodies[i].Y > hy {
-			hy = bodies[i].Y + 1
+
+		mutex.Lock()
+		defer mutex.Unlock()
+		hx, hy, hz = math.Max(hx, h.X), math.Max(hy, h.Y), math.Max(hz, h.Z)
+		lx, ly, lz = math.Min(lx, l.X), math.Min(ly, l.Y), math.Min(lz, l.Z)
+	})
+
+	// Pad the cube slightly so the bodies on the highest
+	// edges are inside it
+	size := math.Max(hx-lx, math.Max(hy-ly, hz-lz))
+	if size == 0 {
+		size = 1
+	}
+	t.size = size * (1 + 1e-9)
+	t.x, t.y, t.z = lx, ly, lz
+}
+
+// key returns the Morton key of a position, the bits of its
+// integer coordinates within the root cube interleaved.
+func (t *Octree) key(p Vector) uint64 {
+	scale := float64(uint64(1)<<mortonBits) / t.size
+	cell := func(v float64) uint64 {
+		c := math.Floor(v * scale)
+		if c < 0 {
+			return 0
 		}
-		if bodies[i].Z > hz {
-			hz = bodies[i].Z + 1
+		if c >= 1<<mortonBits {
+			return 1<<mortonBits - 1
 		}
+		return uint64(c)
+	}
+	return spread(cell(p.X-t.x))<<2 | spread(cell(p.Y-t.y))<<1 | spread(cell(p.Z-t.z))
+}
 
-		// Check if the body is in a lower
-		// position
-		if bodies[i].X < lx {
-			lx = bodies[i].X - 1
+// spread moves the lowest 21 bits of v apart so there are
+// two zero bits between each of them.
+func spread(v uint64) uint64 {
+	v &= 0x1fffff
+	v = (v | v<<32) & 0x1f00000000ffff
+	v = (v | v<<16) & 0x1f0000ff0000ff
+	v = (v | v<<8) & 0x100f00f00f00f00f
+	v = (v | v<<4) & 0x10c30c30c30c30c3
+	v = (v | v<<2) & 0x1249249249249249
+	return v
+}
+
+// sort orders the bodies by their Morton key, bodies with
+// the same key staying in the order they were given.
+func (t *Octree) sort() {
+	n := len(t.bodies)
+	keys := make([]uint64, n)
+	t.index = make([]int, n)
+	parallel(t.workers, n, func(start, end int) {
+		for i := start; i < end; i++ {
+			keys[i] = t.key(t.bodies[i].position())
+			t.index[i] = i
 		}
-		if bodies[i].Y < ly {
-			ly = bodies[i].Y - 1
+	})
+
+	parallelSort(t.workers, t.index, func(a, b int) bool {
+		if keys[a] != keys[b] {
+			return keys[a] < keys[b]
 		}
-		if bodies[i].Z < lz {
-			lz = bodies[i].Z - 1
+		return a < b
+	})
+
+	t.sorted = make([]int, n)
+	t.keys = make([]uint64, n)
+	t.pos = make([]Vector, n)
+	t.mass = make([]float64, n)
+	parallel(t.workers, n, func(start, end int) {
+		for s := start; s < end; s++ {
+			i := t.index[s]
+			t.sorted[i] = s
+			t.keys[s] = keys[i]
+			t.pos[s] = t.bodies[i].position()
+			t.mass[s] = t.bodies[i].mass()
 		}
-	}
+	})
+}
 
-	// create the root node
+// newNode returns a node at the level given whose cube has
+// the integer coordinates ix, iy, iz at that level, holding
+// count sorted bodies from first.
+func (t *Octree) newNode(level uint8, ix, iy, iz uint64, first, count int32) OctNode {
+	size := t.size / float64(uint64(1)<<level)
 	return OctNode{
-		children: make([]OctNode, 0),
-		empty:    true,
-		x:        lx,
-		y:        ly,
-		z:        lz,
-		dx:       hx - lx,
-		dy:       hy - ly,
-		dz:       hz - lz,
+		x:     t.x + float64(ix)*size,
+		y:     t.y + float64(iy)*size,
+		z:     t.z + float64(iz)*size,
+		dx:    size,
+		dy:    size,
+		dz:    size,
+		first: first,
+		count: count,
+		level: level,
 	}
 }
 
-// BuildOcttree creates a new oct tree from a root node
-// and a list of bodies.
-func (n *OctNode) BuildOcttree(bodies []Body) {
-	// Add points to tree
-	for _, body := range bodies {
-		n.OctInsert(body)
+// split divides the node at index i of nodes into a child for
+// each of its octants containing a body, then splits each child
+// in turn, returning the nodes with the new ones appended.
+func (t *Octree) split(nodes []OctNode, i int32) []OctNode {
+	nodes = t.divide(nodes, i)
+	for c := nodes[i].child; c < nodes[i].child+int32(nodes[i].children); c++ {
+		nodes = t.split(nodes, c)
 	}
-
-	// Remove empty leafs
-	n.removeEmpty()
+	return nodes
 }
 
-// OctInsert inserts a body into a oct tree based on the new
-// body's position. If the node that should contain the new
-// body is already filled that node is split into 8 smaller nodes.
-func (n *OctNode) OctInsert(body Body) {
-	// Find the correct node to insert the data into
-	if len(n.children) > 1 {
-		// Check if the node has children
-		// then find the correct leaf to insert the data into
-		// Loop through the children of the node and
-		// check which leaf the data should be contained
-		for i := 0; i < len(n.children); i++ {
-			if n.children[i].inside(body) {
-				n.children[i].OctInsert(body)
-			}
-		}
-	} else if !n.empty && len(n.children) == 0 {
-		// if the node is not empty and does not have any children
-		// split the node into 8 empty leafs.
-		// Then insert the original node's data into a new leaf.
-		// Find the leaf for the new data and insert it.
-
-		// Create the new leafs
-		for i := 0; i < 2; i++ {
-			for j := 0; j < 2; j++ {
-				for k := 0; k < 2; k++ {
-					// Create a new leaf with half the dx, dy and dz
-					n.children = append(
-						n.children,
-						NewOctNode(n,
-							n.x+(float64(i)*(n.dx/2.0)),
-							n.y+(float64(j)*(n.dy/2.0)),
-							n.z+(float64(k)*(n.dz/2.0)),
-						),
-					)
-				}
+// splitParallel splits the root node, then splits each of its
+// children in its own goroutine. The nodes below each child are
+// built in a slice of their own and then joined in the order
+// split would have made them, so the tree is the same as the
+// one built by split.
+func (t *Octree) splitParallel() {
+	t.nodes = t.divide(t.nodes, 0)
+	root := t.nodes[0]
+
+	// The first node of each subtree is the root's child
+	// and the rest are the nodes below it
+	subtrees := make([][]OctNode, root.children)
+	var wg sync.WaitGroup
+	wg.Add(len(subtrees))
+	for c := range subtrees {
+		go func(c int) {
+			defer wg.Done()
+			subtrees[c] = t.split([]OctNode{t.nodes[root.child+int32(c)]}, 0)
+		}(c)
+	}
+	wg.Wait()
+
+	for c, subtree := range subtrees {
+		// Move the subtree's indices from its own slice to
+		// where it starts among the tree's nodes
+		offset := int32(len(t.nodes)) - 1
+		for k := range subtree {
+			if subtree[k].children > 0 {
+				subtree[k].child += offset
 			}
 		}
 
-		// Insert the original node's data into the correct leaf
-		for i := 0; i < len(n.children); i++ {
-			if n.children[i].inside(n.body) {
-				n.children[i].OctInsert(n.body)
-			}
-		}
+		t.nodes[root.child+int32(c)] = subtree[0]
+		t.nodes = append(t.nodes, subtree[1:]...)
+	}
+}
 
-		// Find the child to insert the new data in
-		for i := 0; i < len(n.children); i++ {
-			if n.children[i].inside(body) {
-				n.children[i].OctInsert(body)
-			}
-		}
+// divide appends a child to nodes for each octant of the node
+// at index i which contains a body, when it holds more bodies
+// than the leaf capacity, returning the nodes. Bodies sharing a
+// key cannot be separated, so however many there are they are
+// left together in a leaf at the deepest level.
+func (t *Octree) divide(nodes []OctNode, i int32) []OctNode {
+	n := nodes[i]
+	if n.count <= t.capacity || n.level == mortonBits {
+		return nodes
+	}
 
-		n.body = Body{}
+	// The octant of a body in the node is given by the
+	// next three bits of its key
+	shift := uint(3 * (mortonBits - int(n.level) - 1))
+	end := n.first + n.count
+	ix, iy, iz := t.cell(&n)
+
+	child := int32(len(nodes))
+	start := n.first
+	for octant := uint64(0); octant < 8 && start < end; octant++ {
+		// The bodies are sorted, so the octant ends at the
+		// first body in a later octant
+		stop := start + int32(sort.Search(int(end-start), func(j int) bool {
+			return (t.keys[start+int32(j)]>>shift)&7 > octant
+		}))
+		if stop == start {
+			continue
+		}
 
-	} else if n.empty {
-		// if the node is empty then insert the data into
-		// the leaf node
-		n.body = body
-		n.empty = false
+		nodes = append(nodes, t.newNode(
+			n.level+1,
+			ix*2+(octant>>2)&1,
+			iy*2+(octant>>1)&1,
+			iz*2+octant&1,
+			start,
+			stop-start,
+		))
+		start = stop
 	}
+
+	nodes[i].child = child
+	nodes[i].children = uint8(int32(len(nodes)) - child)
+	return nodes
 }
 
-// inside is true if the Body is within the given
-// node.
-func (n *OctNode) inside(body Body) bool {
-	if body.X >= n.x &&
-		body.X < n.x+n.dx &&
-		body.Y >= n.y &&
-		body.Y < n.y+n.dy &&
-		body.Z >= n.z &&
-		body.Z < n.z+n.dz {
-		return true
-	}
-	return false
+// cell returns the integer coordinates of the node's cube
+// at its level.
+func (t *Octree) cell(n *OctNode) (ix, iy, iz uint64) {
+	if n.count == 0 {
+		return 0, 0, 0
+	}
+
+	// Every body in the node shares the leading bits of
+	// their keys
+	key := t.keys[n.first] >> uint(3*(mortonBits-int(n.level)))
+	for b := uint(0); b < uint(n.level); b++ {
+		ix |= (key >> (3*b + 2) & 1) << b
+		iy |= (key >> (3*b + 1) & 1) << b
+		iz |= (key >> (3 * b) & 1) << b
+	}
+	return ix, iy, iz
 }
 
-// removeEmpty prunes the oct tree of any empty nodes
-// with no children.
-func (n *OctNode) removeEmpty() {
-	// remove any emptyleaf nodes
-	for i := len(n.children) - 1; i >= 0; i-- {
-		if n.children[i].empty && len(n.children) == 0 {
-			// if the leaf is empty and has no children remove
-			n.children = append(n.children[:i], n.children[i+1:]...)
-		} else if len(n.children[i].children) > 0 {
-			// if the node has children check the children
-			// for empty nodes
-			n.children[i].removeEmpty()
+// CalcMass calculates the mass, center of mass and higher
+// moments of every node. The children of a node always come
+// after it, so visiting the nodes backwards finds the moments
+// of the children before those of their parents.
+//
+// With more than one worker the nodes are visited a level at
+// a time from the deepest, the nodes of each level being shared
+// between the workers.
+func (t *Octree) CalcMass() {
+	if t.workers <= 1 {
+		for i := len(t.nodes) - 1; i >= 0; i-- {
+			t.calcNode(&t.nodes[i])
 		}
+		return
 	}
-}
 
-// String returns a string respresentation of a OctNode
-func (n *OctNode) String(indents int) string {
-	var result string
-	if !n.empty {
-		result += fmt.Sprintf(
-			"%vNode: %v mass=%v x=%v+%v y=%v+%v z=%v+%v - Body: mass=%v x=%v y=%v z=%v\n",
-			strings.Repeat("\t", indents),
-			n.body.Name,
-			n.mass,
-			n.x,
-			n.dx,
-			n.y,
-			n.dy,
-			n.z,
-			n.dz,
-			n.body.mass(),
-			n.body.X,
-			n.body.Y,
-			n.body.Z,
-		)
-	} else {
-		result += fmt.Sprintf(
-			"%vNode: %v mass=%v x=%v+%v y=%v+%v z=%v+%v\n",
-			strings.Repeat("\t", indents),
-			n.body.Name,
-			n.mass,
-			n.x,
-			n.dx,
-			n.y,
-			n.dy,
-			n.z,
-			n.dz,
-		)
+	// Group the nodes by their depth
+	var levels [mortonBits + 1][]int32
+	for i := range t.nodes {
+		l := t.nodes[i].level
+		levels[l] = append(levels[l], int32(i))
 	}
-	for i := 0; i < len(n.children); i++ {
-		if len(n.children[i].children) > 0 {
-			result += n.children[i].String(indents + 1)
-		} else {
-			if !n.children[i].empty {
-				result += fmt.Sprintf(
-					"%vNode: %v mass=%v x=%v+%v y=%v+%v z=%v+%v - Body: mass=%v x=%v y=%v z=%v\n",
-					strings.Repeat("\t", indents+1),
-					n.children[i].body.Name,
-					n.children[i].mass,
-					n.children[i].x,
-					n.children[i].dx,
-					n.children[i].y,
-					n.children[i].dy,
-					n.children[i].z,
-					n.children[i].dz,
-					n.children[i].body.mass(),
-					n.children[i].body.X,
-					n.children[i].body.Y,
-					n.children[i].body.Z,
-				)
-			} else {
-				result += fmt.Sprintf(
-					"%vNode: EMPTY %v+%v, %v+%v, %v+%v\n",
-					strings.Repeat("\t", indents+1),
-					n.children[i].x,
-					n.children[i].dx,
-					n.children[i].y,
-					n.children[i].dy,
-					n.children[i].z,
-					n.children[i].dz,
-				)
+
+	for l := mortonBits; l >= 0; l-- {
+		level := levels[l]
+		parallel(t.workers, len(level), func(start, end int) {
+			for _, i := range level[start:end] {
+				t.calcNode(&t.nodes[i])
 			}
-		}
+		})
 	}
-	return result
 }
 
-// CalcMass traverses an oct tree calculating the accumulative
-// mass of each of a nodes children and calculates the position
-// of the node's center of mass
-func (n *OctNode) CalcMass() (totalMass, cmx, cmy, cmz float64) {
-	if len(n.children) > 0 {
-		// If the node has children work out the mass of all of the
-		// children and calculate the center of mass of its children
-		for i := 0; i < len(n.children); i++ {
-			// find the mass and center of each child
-			cMass, cx, cy, cz := n.children[i].CalcMass()
-			totalMass += cMass
-
-			cmx += cx * cMass
-			cmy += cy * cMass
-			cmz += cz * cMass
+// calcNode calculates the mass, center of mass and higher
+// moments of a node from its bodies, or from its children
+// when it has any.
+func (t *Octree) calcNode(n *OctNode) {
+	n.mass, n.cmx, n.cmy, n.cmz = 0, 0, 0, 0
+	n.quad, n.oct = quadrupole{}, octupole{}
+
+	// Start from the node's cube, which is grown to hold
+	// anything that has moved out of it since the tree was
+	// built, see refit
+	cube := t.cube(n)
+	n.x, n.y, n.z, n.dx, n.dy, n.dz = cube.x, cube.y, cube.z, cube.dx, cube.dy, cube.dz
+
+	if n.children == 0 {
+		// A leaf's moments come directly from its bodies
+		for s := n.first; s < n.first+n.count; s++ {
+			n.extend(t.pos[s], t.pos[s])
+			n.mass += t.mass[s]
+			n.cmx += t.pos[s].X * t.mass[s]
+			n.cmy += t.pos[s].Y * t.mass[s]
+			n.cmz += t.pos[s].Z * t.mass[s]
 		}
+		n.center()
 
-		// The mass of the node is the sum of all
-		// children's masses
-		n.mass = totalMass
-
-		// The center of mass for the node is
-		// sum of the product of each child's mass
-		// and center, divided by the total mass of
-		// all children nodes.
-		//
-		// cm = sum(childMass * childCenter) / sum(childrenMass)
-		n.cmx = cmx / totalMass
-		n.cmy = cmx / totalMass
-		n.cmz = cmx / totalMass
-
-		return n.mass, n.cmx, n.cmy, n.cmz
-
-	} else if len(n.children) == 0 && !n.empty {
-		// If the node is a leaf node, a node with a body and no children,
-		// the mass of the node is calculated by the mass of the body
-		// and the center of mass is just the body's position
-		n.mass = n.body.mass()
-		n.cmx = n.body.X
-		n.cmy = n.body.Y
-		n.cmz = n.body.Z
-
-		return n.mass, n.cmx, n.cmy, n.cmz
-	}
-
-	// In this case the node is empty and the mass and center
-	// of mass is 0
-	return n.mass, n.cmx, n.cmy, n.cmz
-}
+		for s := n.first; s < n.first+n.count; s++ {
+			d := Vector{t.pos[s].X - n.cmx, t.pos[s].Y - n.cmy, t.pos[s].Z - n.cmz}
+			addShifted(&n.quad, &n.oct, quadrupole{}, octupole{}, t.mass[s], d)
+		}
+		return
+	}
 
-// CalcForces calculates the force that would be applied
-// to each body in the oct tree and then applies that force
-// to each body.
-func (n *OctNode) CalcForces(grav, theta float64) {
-	leafNodes := n.GetLeafNodes()
+	// The mass of the node is the sum of all
+	// children's masses
+	children := t.nodes[n.child : n.child+int32(n.children)]
+	for c := range children {
+		n.extend(
+			Vector{children[c].x, children[c].y, children[c].z},
+			Vector{children[c].x + children[c].dx, children[c].y + children[c].dy, children[c].z + children[c].dz},
+		)
+		n.mass += children[c].mass
+		n.cmx += children[c].cmx * children[c].mass
+		n.cmy += children[c].cmy * children[c].mass
+		n.cmz += children[c].cmz * children[c].mass
+	}
+	n.center()
 
-	for i := 0; i < len(leafNodes); i++ {
-		// Calculate the force applied to that Body
-		fx, fy, fz := leafNodes[i].treeForce(*n, grav, theta)
-		// Apply force to the body
-		(*leafNodes[i]).body.applyForce(fx, fy, fz)
+	// The higher moments of the node are the sum of the
+	// children's moments moved to the node's center
+	for c := range children {
+		d := Vector{children[c].cmx - n.cmx, children[c].cmy - n.cmy, children[c].cmz - n.cmz}
+		addShifted(&n.quad, &n.oct, children[c].quad, children[c].oct, children[c].mass, d)
 	}
 }
 
-// treeForce calculates the force that should be applied to
-// a particle based on a oct tree.
-func (n *OctNode) treeForce(tree OctNode, grav, theta float64) (fx, fy, fz float64) {
-	// force = G * m * mcm *
-	//             xcm - x       ycm - y         zcm - z
-	//           ( ---------- , ---------- , ---------- )
-	//                r3            r3            r3
+// cube returns a node with the cube the node was given when
+// the tree was built.
+func (t *Octree) cube(n *OctNode) OctNode {
+	ix, iy, iz := t.cell(n)
+	return t.newNode(n.level, ix, iy, iz, n.first, n.count)
+}
 
-	// Do not calculate the force on its self
-	if n.body == tree.body {
-		return 0, 0, 0
+// extend grows the bounds of the node to include the box
+// from lo to hi.
+func (n *OctNode) extend(lo, hi Vector) {
+	n.x, n.dx = extend(n.x, n.dx, lo.X, hi.X)
+	n.y, n.dy = extend(n.y, n.dy, lo.Y, hi.Y)
+	n.z, n.dz = extend(n.z, n.dz, lo.Z, hi.Z)
+}
+
+// extend grows the interval from x to x + dx to include the
+// interval from lo to hi, leaving it untouched when it already
+// does.
+func extend(x, dx, lo, hi float64) (float64, float64) {
+	if lo < x {
+		dx += x - lo
+		x = lo
 	}
+	if hi > x+dx {
+		dx = hi - x
+	}
+	return x, dx
+}
 
-	// 	r = distance from particle i to
-	// 		   center of mass of particles in n
-	//    = sqrt(   ( xcm - x )2
-	//         + ( ycm - y )2
-	//         + ( zcm - z )2 )
+// center divides the mass weighted sum of positions held in
+// the node's center of mass by its mass.
+//
+// cm = sum(mass * position) / sum(mass)
+func (n *OctNode) center() {
+	if n.mass > 0 {
+		n.cmx /= n.mass
+		n.cmy /= n.mass
+		n.cmz /= n.mass
+	}
+}
 
-	dx := tree.cmx - n.body.X
-	dy := tree.cmy - n.body.Y
-	dz := tree.cmz - n.body.Z
+// inside is true if the point is within the given
+// node.
+func (n *OctNode) inside(p Vector) bool {
+	return p.X >= n.x &&
+		p.X < n.x+n.dx &&
+		p.Y >= n.y &&
+		p.Y < n.y+n.dy &&
+		p.Z >= n.z &&
+		p.Z < n.z+n.dz
+}
 
-	r := math.Sqrt(dx*dx + dy*dy + dz*dz)
+// distance returns how far the point x, y, z is from the
+// closest point of the node, 0 if it is inside.
+func (n *OctNode) distance(x, y, z float64) float64 {
+	dx := math.Max(0, math.Max(n.x-x, x-(n.x+n.dx)))
+	dy := math.Max(0, math.Max(n.y-y, y-(n.y+n.dy)))
+	dz := math.Max(0, math.Max(n.z-z, z-(n.z+n.dz)))
+	return math.Sqrt(dx*dx + dy*dy + dz*dz)
+}
 
-	size := tree.dx * tree.dy * tree.dz
+// refit replaces the bodies in the tree with the bodies at the
+// same positions in the slice given, keeping the structure of
+// the tree. The bounds of every node are grown to hold the
+// bodies which have moved out of it and its mass and moments
+// are recalculated, so the tree gives the right forces however
+// far the bodies have moved, just more slowly.
+//
+// It returns the fraction of the bodies that are outside of the
+// cube of the leaf they were placed in, or 1 if the number of
+// bodies has changed and the tree cannot be refitted.
+func (t *Octree) refit(bodies []Body) float64 {
+	if len(bodies) != len(t.bodies) {
+		return 1
+	}
 
-	// If the node is a leaf containing a body
-	if len(tree.children) == 0 && !tree.empty {
-		// Calculate the force on particle
-		fx = grav * n.mass * tree.mass * ((tree.cmx - n.body.X) / (r * r * r))
-		fy = grav * n.mass * tree.mass * ((tree.cmy - n.body.Y) / (r * r * r))
-		fz = grav * n.mass * tree.mass * ((tree.cmz - n.body.Z) / (r * r * r))
+	t.bodies = bodies
+	parallel(t.workers, len(t.index), func(start, end int) {
+		for s := start; s < end; s++ {
+			i := t.index[s]
+			t.pos[s] = bodies[i].position()
+			t.mass[s] = bodies[i].mass()
+		}
+	})
 
-		return fx, fy, fz
+	var escaped int
+	for i := range t.nodes {
+		n := &t.nodes[i]
+		if n.children > 0 {
+			continue
+		}
+
+		cube := t.cube(n)
+		for s := n.first; s < n.first+n.count; s++ {
+			if !cube.inside(t.pos[s]) {
+				escaped++
+			}
+		}
 	}
 
-	if size/r < theta {
-		// Calc the force on particle
-		fx = grav * n.mass * tree.mass * ((tree.cmx - n.body.Z) / (r * r * r))
-		fy = grav * n.mass * tree.mass * ((tree.cmy - n.body.Y) / (r * r * r))
-		fz = grav * n.mass * tree.mass * ((tree.cmz - n.body.Z) / (r * r * r))
+	t.CalcMass()
 
-		return fx, fy, fz
+	if len(t.bodies) == 0 {
+		return 0
 	}
+	return float64(escaped) / float64(len(t.bodies))
+}
 
-	for i := 0; i < len(tree.children); i++ {
-		// Calculate the resulting force of all
-		// of the nodes children's forces
-		ifx, ify, ifz := n.treeForce(tree.children[i], grav, theta)
-		fx += ifx
-		fy += ify
-		fz += ifz
+// setDepthThreshold sets the fraction of the depth of its leaves
+// the tree can lose, DefaultDepthThreshold when it is 0.
+func (t *Octree) setDepthThreshold(threshold float64) {
+	t.depthThreshold = threshold
+	if t.depthThreshold <= 0 {
+		t.depthThreshold = DefaultDepthThreshold
 	}
-
-	return fx, fy, fz
 }
 
-// GetLeafNodes returns a list of all the nodes that contain
-// a body.
-func (n *OctNode) GetLeafNodes() (leafNodes []*OctNode) {
-	// If the node has children, check each child for
-	// leaf nodes
-	if len(n.children) > 0 {
-		for i := 0; i < len(n.children); i++ {
-			tmpLeafNodes := n.children[i].GetLeafNodes()
-			leafNodes = append(leafNodes, tmpLeafNodes...)
+// depthLoss returns the fraction of the mean depth of the leaves
+// that the tree has lost since it was built. A leaf's depth is
+// measured from the side of its bounds, which is the side of its
+// cube when the tree is built but grows as refit stretches it to
+// hold the bodies which have moved out, so a tree whose leaves
+// have spread over each other is shallower than its levels.
+func (t *Octree) depthLoss() float64 {
+	var built, depth float64
+	for i := range t.nodes {
+		n := &t.nodes[i]
+		if n.children > 0 || n.count == 0 {
+			continue
 		}
-	} else if len(n.children) == 0 && !n.empty {
-		// Found a leaf node return add the node to be
-		// returned
-		leafNodes = append(leafNodes, n)
+
+		side := math.Max(n.dx, math.Max(n.dy, n.dz))
+		built += float64(n.level)
+		depth += math.Max(0, math.Log2(t.size/side))
+	}
+
+	if built == 0 {
+		return 0
 	}
+	return 1 - depth/built
+}
 
-	return leafNodes
+// unbalanced returns whether the tree has lost more than its
+// depth threshold of the depth of its leaves, see depthLoss.
+func (t *Octree) unbalanced() bool {
+	return t.depthLoss() > t.depthThreshold
 }
 
-// GetBodies returns all of the Body struts stored
-// in a oct tree
-func (n *OctNode) GetBodies() (bodies []Body) {
-	// Get all the leaf nodes in the oct tree
-	leafNodes := n.GetLeafNodes()
-	// Retreive the body's attached to each
-	// leaf node
-	for i := 0; i < len(leafNodes); i++ {
-		bodies = append(bodies, leafNodes[i].body)
+// GetBodies returns all of the Body structs stored in the
+// oct tree, in the order they are sorted in the tree.
+func (t *Octree) GetBodies() (bodies []Body) {
+	for _, i := range t.index {
+		bodies = append(bodies, t.bodies[i])
 	}
 	return bodies
 }
+
+// String returns a string respresentation of the Octree, each
+// node being indented by its depth.
+func (t *Octree) String() string {
+	var result strings.Builder
+	for _, i := range t.order() {
+		n := &t.nodes[i]
+		fmt.Fprintf(
+			&result,
+			"%vNode: mass=%v x=%v+%v y=%v+%v z=%v+%v",
+			strings.Repeat("\t", int(n.level)),
+			n.mass,
+			n.x,
+			n.dx,
+			n.y,
+			n.dy,
+			n.z,
+			n.dz,
+		)
+
+		if n.children == 0 {
+			for s := n.first; s < n.first+n.count; s++ {
+				fmt.Fprintf(
+					&result,
+					" - Body: %v mass=%v x=%v y=%v z=%v",
+					t.bodies[t.index[s]].Name,
+					t.mass[s],
+					t.pos[s].X,
+					t.pos[s].Y,
+					t.pos[s].Z,
+				)
+			}
+		}
+		result.WriteString("\n")
+	}
+	return result.String()
+}
+
+// order returns the index of every node in depth first order,
+// each node being followed by its children.
+func (t *Octree) order() []int32 {
+	order := make([]int32, 0, len(t.nodes))
+
+	stack := []int32{0}
+	for len(stack) > 0 {
+		i := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		order = append(order, i)
+
+		// Push the children backwards so the first child
+		// is visited first
+		n := &t.nodes[i]
+		for c := n.child + int32(n.children) - 1; c >= n.child; c-- {
+			stack = append(stack, c)
+		}
+	}
+	return order
+}
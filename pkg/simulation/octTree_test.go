@@ -0,0 +1,257 @@
+package simulation
+
+import (
+	"math"
+	"testing"
+)
+
+func TestOctreeStructure(t *testing.T) {
+	bodies := cloud(200, 5)
+	tree := NewOctree(bodies, TreeOptions{})
+
+	// The bodies are sorted by their Morton key
+	for s := 1; s < len(tree.keys); s++ {
+		if tree.keys[s-1] > tree.keys[s] {
+			t.Fatalf("body %d is out of order", s)
+		}
+	}
+
+	var leaves int32
+	for i := range tree.nodes {
+		n := &tree.nodes[i]
+		if n.count == 0 {
+			t.Fatalf("node %d holds no bodies", i)
+		}
+		if n.children == 0 {
+			leaves += n.count
+			for s := n.first; s < n.first+n.count; s++ {
+				if !n.inside(tree.pos[s]) {
+					t.Fatalf("body %d is outside of its leaf", tree.index[s])
+				}
+			}
+			continue
+		}
+
+		// The children of a node hold all of its bodies
+		// between them
+		var count int32
+		for c := n.child; c < n.child+int32(n.children); c++ {
+			child := &tree.nodes[c]
+			if child.level != n.level+1 || child.first != n.first+count {
+				t.Fatalf("node %d has a misplaced child %d", i, c)
+			}
+			count += child.count
+		}
+		if count != n.count {
+			t.Fatalf("node %d holds %d bodies, its children %d", i, n.count, count)
+		}
+	}
+	if leaves != int32(len(bodies)) {
+		t.Fatalf("expected %d bodies in the leaves, got %d", len(bodies), leaves)
+	}
+
+	var mass float64
+	for i := range bodies {
+		mass += bodies[i].mass()
+	}
+	if d := tree.nodes[0].mass - mass; d > 1e-9 || d < -1e-9 {
+		t.Fatalf("expected a root mass of %g, got %g", mass, tree.nodes[0].mass)
+	}
+}
+
+func TestOctreeForceAxes(t *testing.T) {
+	axes := []Vector{{X: 1}, {Y: 1}, {Z: 1}}
+	for _, axis := range axes {
+		// Two bodies of different mass 3 apart along the axis
+		bodies := []Body{
+			{Name: "a", X: 1, Y: 2, Z: 3, Radius: 1, Density: 1},
+			{Name: "b", X: 1 + 3*axis.X, Y: 2 + 3*axis.Y, Z: 3 + 3*axis.Z, Radius: 1, Density: 2},
+		}
+		tree := NewOctree(bodies, TreeOptions{})
+		acc := tree.Accelerations(ForceOptions{Grav: 1, MAC: GeometricMAC{Theta: 0.5}, Kernel: KernelNone})
+
+		// Each is pulled towards the other by Newton's law
+		ma, mb := bodies[0].Mass(), bodies[1].Mass()
+		if want := axis.Scale(mb / 9); acc[0].Sub(want).Length() > 1e-12*want.Length() {
+			t.Fatalf("axis %v: expected an acceleration of %v, got %v", axis, want, acc[0])
+		}
+		if want := axis.Scale(-ma / 9); acc[1].Sub(want).Length() > 1e-12*want.Length() {
+			t.Fatalf("axis %v: expected an acceleration of %v, got %v", axis, want, acc[1])
+		}
+
+		direct := NewSimulation(1, 0, bodies...).DirectAccelerations()
+		if e := maxRelativeError(acc, direct); e > 1e-12 {
+			t.Fatalf("axis %v: expected the direct forces, got an error of %g", axis, e)
+		}
+	}
+}
+
+func TestOctreeCenterOfMass(t *testing.T) {
+	bodies := []Body{
+		{Name: "a", X: -3, Y: 1, Z: 2, Radius: 1, Density: 1},
+		{Name: "b", X: 4, Y: -2, Z: 0.5, Radius: 0.5, Density: 5},
+		{Name: "c", X: 0.5, Y: 6, Z: -1, Radius: 2, Density: 0.1},
+		{Name: "d", X: 1, Y: 1, Z: 7, Radius: 0.1, Density: 100},
+	}
+
+	var mass float64
+	var weighted Vector
+	for _, b := range bodies {
+		mass += b.Mass()
+		weighted = weighted.Add(Vector{b.X, b.Y, b.Z}.Scale(b.Mass()))
+	}
+	center := weighted.Scale(1 / mass)
+
+	root := NewOctree(bodies, TreeOptions{}).Export()[0]
+	if math.Abs(root.Mass-mass) > 1e-12*mass {
+		t.Fatalf("expected the root to hold a mass of %v, got %v", mass, root.Mass)
+	}
+	if root.CenterOfMass.Sub(center).Length() > 1e-12 {
+		t.Fatalf("expected the center of mass at %v, got %v", center, root.CenterOfMass)
+	}
+}
+
+func TestOctreeCoincidentBodies(t *testing.T) {
+	bodies := []Body{
+		{Name: "a", X: 1, Y: 1, Z: 1, Radius: 0.01, Density: unitMassDensity},
+		{Name: "b", X: 1, Y: 1, Z: 1, Radius: 0.01, Density: unitMassDensity},
+		{Name: "c", X: 2, Y: 1, Z: 1, Radius: 0.01, Density: unitMassDensity},
+	}
+	tree := NewOctree(bodies, TreeOptions{})
+
+	if got := len(tree.GetBodies()); got != len(bodies) {
+		t.Fatalf("expected %d bodies in the tree, got %d", len(bodies), got)
+	}
+
+	opts := ForceOptions{Grav: 1, MAC: GeometricMAC{Theta: 0.5}, Softening: 0.1, Kernel: KernelPlummer}
+	for i, a := range tree.Accelerations(opts) {
+		if a.X != a.X || a.Y != a.Y || a.Z != a.Z {
+			t.Fatalf("body %d has an acceleration of %v", i, a)
+		}
+	}
+}
+
+func TestOctreeLeafCapacity(t *testing.T) {
+	bodies := cloud(500, 9)
+	exact := NewSimulation(1, 0, bodies...)
+	exact.Softening = 0.01
+	direct := exact.DirectAccelerations()
+
+	for _, capacity := range []int{1, 4, 16} {
+		tree := NewOctree(bodies, TreeOptions{LeafCapacity: capacity})
+		for i := range tree.nodes {
+			n := &tree.nodes[i]
+			if n.children == 0 && n.count > int32(capacity) {
+				t.Fatalf("leaf %d holds %d bodies with a capacity of %d", i, n.count, capacity)
+			}
+		}
+
+		// Opening every node sums every pair directly however
+		// the bodies are grouped
+		opts := ForceOptions{Grav: 1, MAC: GeometricMAC{Theta: 0}, Softening: 0.01, Kernel: DefaultKernel}
+		if e := maxRelativeError(tree.Accelerations(opts), direct); e > 1e-9 {
+			t.Fatalf("expected exact forces with a capacity of %d, got an error of %g", capacity, e)
+		}
+	}
+}
+
+func TestOctreeNoMAC(t *testing.T) {
+	bodies := cloud(200, 3)
+	exact := NewSimulation(1, 0, bodies...)
+	exact.Softening = 0.01
+	direct := exact.DirectAccelerations()
+
+	// Without a criterion every node is opened
+	tree := NewOctree(bodies, TreeOptions{})
+	opts := ForceOptions{Grav: 1, Softening: 0.01, Kernel: DefaultKernel}
+	if e := maxRelativeError(tree.Accelerations(opts), direct); e > 1e-9 {
+		t.Fatalf("expected exact forces without a criterion, got an error of %g", e)
+	}
+}
+
+func TestDuplicatePositions(t *testing.T) {
+	var bodies []Body
+	for i := 0; i < 20; i++ {
+		bodies = append(bodies, Body{X: 1, Y: 2, Z: 3, Radius: 0.01, Density: unitMassDensity})
+	}
+	bodies = append(bodies, Body{X: 2, Y: 2, Z: 3, Radius: 0.01, Density: unitMassDensity})
+
+	for _, capacity := range []int{1, 8} {
+		sim := NewSimulation(1, 0.5, bodies...)
+		sim.Softening = 0.1
+		sim.LeafCapacity = capacity
+		sim.Steps(2)
+
+		for i, b := range sim.Bodies {
+			if math.IsNaN(b.X) || math.IsNaN(b.VX) {
+				t.Fatalf("body %d has moved to %v with a capacity of %d", i, b, capacity)
+			}
+		}
+	}
+}
+
+func TestOctreeRefit(t *testing.T) {
+	bodies := cloud(300, 13)
+	tree := NewOctree(bodies, TreeOptions{})
+
+	if f := tree.refit(bodies); f != 0 {
+		t.Fatalf("expected no bodies to have left their leaf, got %g", f)
+	}
+
+	// Move every body a long way so they leave their leaves
+	moved := append([]Body(nil), bodies...)
+	for i := range moved {
+		moved[i].X += 0.5 * float64(i%3)
+	}
+	if f := tree.refit(moved); f <= 0 {
+		t.Fatalf("expected bodies to have left their leaf, got %g", f)
+	}
+
+	// The grown bounds still hold every body
+	for i := range tree.nodes {
+		n := &tree.nodes[i]
+		for s := n.first; s < n.first+n.count; s++ {
+			if n.distance(tree.pos[s].X, tree.pos[s].Y, tree.pos[s].Z) > 1e-12 {
+				t.Fatalf("body %d is outside of node %d", tree.index[s], i)
+			}
+		}
+	}
+
+	exact := NewSimulation(1, 0, moved...)
+	exact.Softening = 0.01
+	opts := ForceOptions{Grav: 1, MAC: GeometricMAC{Theta: 0}, Softening: 0.01, Kernel: DefaultKernel}
+	if e := maxRelativeError(tree.Accelerations(opts), exact.DirectAccelerations()); e > 1e-9 {
+		t.Fatalf("expected exact forces from the refitted tree, got an error of %g", e)
+	}
+
+	if f := tree.refit(moved[1:]); f != 1 {
+		t.Fatalf("expected a tree with a different number of bodies not to refit, got %g", f)
+	}
+}
+
+func TestOctreeDepthLoss(t *testing.T) {
+	bodies := cloud(300, 13)
+	tree := NewOctree(bodies, TreeOptions{})
+
+	tree.refit(bodies)
+	if l := tree.depthLoss(); math.Abs(l) > 1e-9 || tree.unbalanced() {
+		t.Fatalf("expected a freshly built tree to have lost no depth, got %g", l)
+	}
+
+	// Mirroring the bodies stretches every leaf over the others
+	moved := append([]Body(nil), bodies...)
+	for i := range moved {
+		moved[i].X = -moved[i].X
+		moved[i].Y = -moved[i].Y
+	}
+	tree.refit(moved)
+	if l := tree.depthLoss(); l <= DefaultDepthThreshold || !tree.unbalanced() {
+		t.Fatalf("expected a scattered tree to be unbalanced, lost %g of its depth", l)
+	}
+
+	loose := NewOctree(bodies, TreeOptions{DepthThreshold: 1})
+	loose.refit(moved)
+	if loose.unbalanced() {
+		t.Fatalf("expected a threshold of 1 to keep the scattered tree, lost %g of its depth", loose.depthLoss())
+	}
+}
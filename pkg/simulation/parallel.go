@@ -0,0 +1,176 @@
+package simulation
+
+import (
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// chunkSize is the number of items a worker takes
+	// at a time.
+	chunkSize = 64
+)
+
+// workerCount returns the number of workers to use when
+// workers have been requested, all of the available processors
+// when it is not positive.
+func workerCount(workers int) int {
+	if workers <= 0 {
+		return runtime.GOMAXPROCS(0)
+	}
+	return workers
+}
+
+// panics holds the first panic raised by a group of workers so
+// that it can be raised again on the goroutine waiting for
+// them, where the caller can recover from it. A panic left in a
+// worker goroutine would crash the whole program.
+type panics struct {
+	once  sync.Once
+	value interface{}
+}
+
+// recover records the panic of the worker it is deferred in,
+// keeping only the first one.
+func (p *panics) recover() {
+	if v := recover(); v != nil {
+		p.once.Do(func() {
+			p.value = v
+		})
+	}
+}
+
+// raise panics again with the recorded panic, if there is one,
+// once every worker has finished.
+func (p *panics) raise() {
+	if p.value != nil {
+		panic(p.value)
+	}
+}
+
+// parallel calls fn for consecutive ranges start to end
+// covering 0 to n, spread across a number of goroutines. The
+// ranges are handed out as the workers become free, so the
+// work is balanced even when some items take longer than
+// others. If fn panics in any worker, parallel panics with
+// the first value once the other workers have finished.
+func parallel(workers, n int, fn func(start, end int)) {
+	workers = workerCount(workers)
+	if max := (n + chunkSize - 1) / chunkSize; workers > max {
+		workers = max
+	}
+	if workers <= 1 {
+		if n > 0 {
+			fn(0, n)
+		}
+		return
+	}
+
+	var next int64
+	var wg sync.WaitGroup
+	var p panics
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			defer p.recover()
+			for {
+				start := int(atomic.AddInt64(&next, chunkSize)) - chunkSize
+				if start >= n {
+					return
+				}
+				end := start + chunkSize
+				if end > n {
+					end = n
+				}
+				fn(start, end)
+			}
+		}()
+	}
+	wg.Wait()
+	p.raise()
+}
+
+// parallelSort sorts the indices by less, sorting a part of
+// them in each worker and then merging the parts in pairs.
+// less must be a strict total order so the result is the same
+// as sorting them in one go. A panic in less is raised again
+// on the caller, as in parallel.
+func parallelSort(workers int, indices []int, less func(a, b int) bool) {
+	workers = workerCount(workers)
+	if max := len(indices) / chunkSize; workers > max {
+		workers = max
+	}
+	if workers <= 1 {
+		sort.Slice(indices, func(a, b int) bool {
+			return less(indices[a], indices[b])
+		})
+		return
+	}
+
+	// Sort each part on its own
+	bounds := make([]int, workers+1)
+	for w := range bounds {
+		bounds[w] = w * len(indices) / workers
+	}
+	var wg sync.WaitGroup
+	var failed panics
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func(part []int) {
+			defer wg.Done()
+			defer failed.recover()
+			sort.Slice(part, func(a, b int) bool {
+				return less(part[a], part[b])
+			})
+		}(indices[bounds[w]:bounds[w+1]])
+	}
+	wg.Wait()
+	failed.raise()
+
+	// Merge neighbouring parts until only one is left
+	src, dst := indices, make([]int, len(indices))
+	for len(bounds) > 2 {
+		var merged []int
+		for p := 0; p+1 < len(bounds); p += 2 {
+			merged = append(merged, bounds[p])
+			if p+2 >= len(bounds) {
+				// The last part has nothing to merge with
+				copy(dst[bounds[p]:bounds[p+1]], src[bounds[p]:bounds[p+1]])
+				continue
+			}
+
+			wg.Add(1)
+			go func(lo, mid, hi int) {
+				defer wg.Done()
+				defer failed.recover()
+				mergeSorted(dst[lo:hi], src[lo:mid], src[mid:hi], less)
+			}(bounds[p], bounds[p+1], bounds[p+2])
+		}
+		wg.Wait()
+		failed.raise()
+
+		bounds = append(merged, len(indices))
+		src, dst = dst, src
+	}
+	if &src[0] != &indices[0] {
+		copy(indices, src)
+	}
+}
+
+// mergeSorted merges the sorted slices a and b into dst, taking from
+// a first when the items are equal.
+func mergeSorted(dst, a, b []int, less func(a, b int) bool) {
+	i, j := 0, 0
+	for k := range dst {
+		if j >= len(b) || (i < len(a) && !less(b[j], a[i])) {
+			dst[k] = a[i]
+			i++
+		} else {
+			dst[k] = b[j]
+			j++
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package simulation
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParallelForces(t *testing.T) {
+	tree := NewOctree(cloud(1000, 7), TreeOptions{})
+	opts := ForceOptions{Grav: 1, MAC: GeometricMAC{Theta: 0.5}, Order: OrderQuadrupole, Softening: 0.01, Kernel: KernelPlummer}
+
+	opts.Workers = 1
+	serial := tree.Accelerations(opts)
+	serialPotential := tree.Potential(opts)
+
+	// Each body's walk does not depend on the others, so
+	// sharing them out gives exactly the same results
+	opts.Workers = 4
+	if !reflect.DeepEqual(serial, tree.Accelerations(opts)) {
+		t.Fatalf("expected the same accelerations with 4 workers")
+	}
+	if p := tree.Potential(opts); p != serialPotential {
+		t.Fatalf("expected a potential of %g with 4 workers, got %g", serialPotential, p)
+	}
+
+	indices := []int{999, 0, 500}
+	for k, a := range tree.AccelerationsOn(indices, opts) {
+		if a != serial[indices[k]] {
+			t.Fatalf("expected body %d to have acceleration %v, got %v", indices[k], serial[indices[k]], a)
+		}
+	}
+}
+
+func TestParallelBuild(t *testing.T) {
+	bodies := cloud(5000, 11)
+	serial := NewOctree(bodies, TreeOptions{Workers: 1})
+
+	for _, workers := range []int{2, 3, 8} {
+		tree := NewOctree(bodies, TreeOptions{Workers: workers})
+		tree.workers = serial.workers
+
+		if !reflect.DeepEqual(serial, tree) {
+			t.Fatalf("expected the tree built with %d workers to be the same as the serial one", workers)
+		}
+	}
+}
+
+func TestParallelSort(t *testing.T) {
+	rng := newRNG(3)
+	values := make([]uint64, 10000)
+	indices := make([]int, len(values))
+	for i := range values {
+		// Use few values so there are plenty of ties
+		values[i] = rng.uint64() % 100
+		indices[i] = i
+	}
+	less := func(a, b int) bool {
+		if values[a] != values[b] {
+			return values[a] < values[b]
+		}
+		return a < b
+	}
+
+	parallelSort(5, indices, less)
+	for k := 1; k < len(indices); k++ {
+		if !less(indices[k-1], indices[k]) {
+			t.Fatalf("index %d is out of order", k)
+		}
+	}
+}
+
+func TestParallelPanic(t *testing.T) {
+	for _, workers := range []int{1, 4} {
+		func() {
+			defer func() {
+				if p := recover(); p != "boom" {
+					t.Fatalf("expected the panic to reach the caller with %d workers, got %v", workers, p)
+				}
+			}()
+
+			// Every worker panics, but only one is raised
+			parallel(workers, 1000, func(start, end int) {
+				panic("boom")
+			})
+			t.Fatalf("expected parallel to panic with %d workers", workers)
+		}()
+	}
+
+	defer func() {
+		if p := recover(); p != "boom" {
+			t.Fatalf("expected the panic of less to reach the caller, got %v", p)
+		}
+	}()
+	indices := make([]int, 1000)
+	parallelSort(4, indices, func(a, b int) bool {
+		panic("boom")
+	})
+	t.Fatalf("expected parallelSort to panic")
+}
@@ -0,0 +1,151 @@
+package simulation
+
+import (
+	"container/heap"
+	"sort"
+)
+
+// WithinRadius returns the indices of the bodies in the oct
+// tree whose position is less than r from the point p, in the
+// order of the bodies the tree was built from.
+func (t *Octree) WithinRadius(p Vector, r float64) []int {
+	var found []int
+	t.search(func(n *OctNode) bool {
+		return n.distance(p.X, p.Y, p.Z) < r
+	}, func(s int) {
+		if t.pos[s].Sub(p).Length() < r {
+			found = append(found, t.index[s])
+		}
+	})
+
+	sort.Ints(found)
+	return found
+}
+
+// InBox returns the indices of the bodies in the oct tree
+// whose position is inside the axis aligned box from lo to hi,
+// including its faces, in the order of the bodies the tree was
+// built from.
+func (t *Octree) InBox(lo, hi Vector) []int {
+	inside := func(p Vector) bool {
+		return p.X >= lo.X && p.X <= hi.X &&
+			p.Y >= lo.Y && p.Y <= hi.Y &&
+			p.Z >= lo.Z && p.Z <= hi.Z
+	}
+
+	var found []int
+	t.search(func(n *OctNode) bool {
+		return n.x <= hi.X && n.x+n.dx >= lo.X &&
+			n.y <= hi.Y && n.y+n.dy >= lo.Y &&
+			n.z <= hi.Z && n.z+n.dz >= lo.Z
+	}, func(s int) {
+		if inside(t.pos[s]) {
+			found = append(found, t.index[s])
+		}
+	})
+
+	sort.Ints(found)
+	return found
+}
+
+// KNearest returns the indices of the k bodies in the oct tree
+// closest to the point p, nearest first. Fewer are returned when
+// the tree holds fewer than k bodies.
+func (t *Octree) KNearest(p Vector, k int) []int {
+	return t.nearest(p, k, -1)
+}
+
+// KNearestBody returns the indices of the k bodies in the oct
+// tree closest to the body at index i of the slice the tree
+// was built from, nearest first, leaving out the body itself.
+func (t *Octree) KNearestBody(i, k int) []int {
+	return t.nearest(t.pos[t.sorted[i]], k, t.sorted[i])
+}
+
+// search visits the nodes of the tree that open accepts and
+// passes each sorted body in the leaves it reaches to visit.
+func (t *Octree) search(open func(n *OctNode) bool, visit func(s int)) {
+	stack := []int32{0}
+	for len(stack) > 0 {
+		n := &t.nodes[stack[len(stack)-1]]
+		stack = stack[:len(stack)-1]
+
+		if n.count == 0 || !open(n) {
+			continue
+		}
+
+		if n.children > 0 {
+			for c := n.child; c < n.child+int32(n.children); c++ {
+				stack = append(stack, c)
+			}
+			continue
+		}
+
+		for s := n.first; s < n.first+n.count; s++ {
+			visit(int(s))
+		}
+	}
+}
+
+// nearest returns the indices of the k bodies closest to the
+// point p, leaving out the sorted body exclude.
+func (t *Octree) nearest(p Vector, k int, exclude int) []int {
+	if k <= 0 {
+		return nil
+	}
+
+	// The candidates are kept in a heap with the furthest
+	// on top, so it can be replaced by anything closer
+	best := &neighbours{}
+	t.search(func(n *OctNode) bool {
+		return best.Len() < k || n.distance(p.X, p.Y, p.Z) <= (*best)[0].distance
+	}, func(s int) {
+		if s == exclude {
+			return
+		}
+
+		c := neighbour{index: t.index[s], distance: t.pos[s].Sub(p).Length()}
+		if best.Len() < k {
+			heap.Push(best, c)
+		} else if c.closer((*best)[0]) {
+			(*best)[0] = c
+			heap.Fix(best, 0)
+		}
+	})
+
+	// Empty the heap from the furthest to the nearest
+	found := make([]int, best.Len())
+	for i := len(found) - 1; i >= 0; i-- {
+		found[i] = heap.Pop(best).(neighbour).index
+	}
+	return found
+}
+
+// neighbour is a body found by a nearest neighbour search.
+type neighbour struct {
+	index    int
+	distance float64
+}
+
+// closer reports whether the neighbour is closer than o, the
+// lower index being closer when they are the same distance.
+func (n neighbour) closer(o neighbour) bool {
+	if n.distance != o.distance {
+		return n.distance < o.distance
+	}
+	return n.index < o.index
+}
+
+// neighbours is a heap of neighbours with the furthest first.
+type neighbours []neighbour
+
+func (h neighbours) Len() int            { return len(h) }
+func (h neighbours) Less(i, j int) bool  { return h[j].closer(h[i]) }
+func (h neighbours) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *neighbours) Push(x interface{}) { *h = append(*h, x.(neighbour)) }
+func (h *neighbours) Pop() interface{} {
+	old := *h
+	n := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return n
+}
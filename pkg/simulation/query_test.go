@@ -0,0 +1,51 @@
+package simulation
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestOctreeQueries(t *testing.T) {
+	bodies := cloud(500, 19)
+	tree := NewOctree(bodies, TreeOptions{LeafCapacity: 4})
+	p := Vector{0.4, 0.5, 0.6}
+
+	// Compare every query with a scan over all of the bodies
+	var within, inBox []int
+	for i := range bodies {
+		q := bodies[i].position()
+		if q.Sub(p).Length() < 0.2 {
+			within = append(within, i)
+		}
+		if q.X >= 0.1 && q.X <= 0.3 && q.Y >= 0.2 && q.Y <= 0.7 && q.Z >= 0 && q.Z <= 0.5 {
+			inBox = append(inBox, i)
+		}
+	}
+	if got := tree.WithinRadius(p, 0.2); !reflect.DeepEqual(got, within) {
+		t.Fatalf("expected %v within the radius, got %v", within, got)
+	}
+	if got := tree.InBox(Vector{0.1, 0.2, 0}, Vector{0.3, 0.7, 0.5}); !reflect.DeepEqual(got, inBox) {
+		t.Fatalf("expected %v in the box, got %v", inBox, got)
+	}
+
+	byDistance := func(from Vector) []int {
+		order := make([]int, len(bodies))
+		for i := range order {
+			order[i] = i
+		}
+		sort.SliceStable(order, func(a, b int) bool {
+			return bodies[order[a]].position().Sub(from).Length() < bodies[order[b]].position().Sub(from).Length()
+		})
+		return order
+	}
+	if got, want := tree.KNearest(p, 10), byDistance(p)[:10]; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected the nearest bodies %v, got %v", want, got)
+	}
+	if got, want := tree.KNearestBody(42, 5), byDistance(bodies[42].position())[1:6]; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected the nearest bodies to body 42 %v, got %v", want, got)
+	}
+	if got := tree.KNearest(p, 1000); len(got) != len(bodies) {
+		t.Fatalf("expected every body when k is larger than the tree, got %d", len(got))
+	}
+}
@@ -0,0 +1,27 @@
+package simulation
+
+// rng is a small SplitMix64 random number generator. Its
+// whole state is a single number so that it can be saved
+// and restored exactly.
+type rng struct {
+	state uint64
+}
+
+// newRNG returns a generator seeded with seed.
+func newRNG(seed int64) *rng {
+	return &rng{state: uint64(seed)}
+}
+
+// uint64 returns the next pseudo-random number.
+func (r *rng) uint64() uint64 {
+	r.state += 0x9e3779b97f4a7c15
+	z := r.state
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+// float64 returns a pseudo-random number in [0, 1).
+func (r *rng) float64() float64 {
+	return float64(r.uint64()>>11) / (1 << 53)
+}
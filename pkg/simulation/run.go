@@ -0,0 +1,89 @@
+package simulation
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Progress describes how far a call to Run has got.
+type Progress struct {
+	// Step is the simulation's current step and Time its
+	// simulated time.
+	Step int     `json:"step"`
+	Time float64 `json:"time"`
+	// Done is the number of steps taken by this run out of
+	// Total.
+	Done  int `json:"done"`
+	Total int `json:"total"`
+	// StepDuration is the wall time the last step took and
+	// Elapsed the wall time since the run started.
+	StepDuration time.Duration `json:"stepDuration"`
+	Elapsed      time.Duration `json:"elapsed"`
+}
+
+// ProgressFunc is called by Run after each step.
+type ProgressFunc func(p Progress)
+
+// CancelledError is returned by Run when its context is
+// cancelled before all of the steps have been taken.
+type CancelledError struct {
+	// Step is the step the simulation stopped at and Done
+	// the number of steps the run took.
+	Step int
+	Done int
+	// Err is the context's error.
+	Err error
+}
+
+func (e *CancelledError) Error() string {
+	return fmt.Sprintf("simulation stopped at step %d after %d steps: %v", e.Step, e.Done, e.Err)
+}
+
+// Unwrap returns the context's error, so errors.Is can tell
+// a cancelled run from one that ran out of time.
+func (e *CancelledError) Unwrap() error {
+	return e.Err
+}
+
+// Run simulates a number of steps, calling progress after each
+// one when it is not nil. The context is checked before every
+// step, and when it is done the run stops with the simulation
+// at the end of the last step and returns a *CancelledError. An
+// error is returned without taking any steps if the simulation
+// is not valid, see Validate.
+func (s *Simulation) Run(ctx context.Context, steps int, progress ProgressFunc) error {
+	if err := s.Validate(); err != nil {
+		return err
+	}
+	integrator, _ := NewIntegrator(s.Integrator)
+
+	// Record the starting state to measure drift from
+	if len(s.Diagnostics) == 0 {
+		s.Diagnostics = append(s.Diagnostics, s.Diagnose())
+	}
+
+	start := time.Now()
+	for i := 0; i < steps; i++ {
+		if err := ctx.Err(); err != nil {
+			s.logf("step %d: stopped after %d of %d steps: %v", s.Step, i, steps, err)
+			return &CancelledError{Step: s.Step, Done: i, Err: err}
+		}
+
+		began := time.Now()
+		s.step(integrator)
+
+		if progress != nil {
+			now := time.Now()
+			progress(Progress{
+				Step:         s.Step,
+				Time:         s.Time,
+				Done:         i + 1,
+				Total:        steps,
+				StepDuration: now.Sub(began),
+				Elapsed:      now.Sub(start),
+			})
+		}
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package simulation
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestRunCancel(t *testing.T) {
+	sim := NewSimulation(1, 0, pair()...)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var reports []Progress
+	err := sim.Run(ctx, 10, func(p Progress) {
+		reports = append(reports, p)
+		if p.Done == 3 {
+			cancel()
+		}
+	})
+
+	var cancelled *CancelledError
+	if !errors.As(err, &cancelled) || !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected a cancelled error, got %v", err)
+	}
+	if cancelled.Step != 3 || cancelled.Done != 3 || sim.Step != 3 {
+		t.Fatalf("expected to stop at step 3, stopped at %d after %d", sim.Step, cancelled.Done)
+	}
+	if len(reports) != 3 || reports[2].Total != 10 || reports[2].Time != sim.Time {
+		t.Fatalf("unexpected progress %+v", reports)
+	}
+
+	// The run can be carried on from where it stopped
+	if err := sim.Run(context.Background(), 2, nil); err != nil || sim.Step != 5 {
+		t.Fatalf("expected to carry on to step 5, got step %d and %v", sim.Step, err)
+	}
+}
+
+func TestRunInvalid(t *testing.T) {
+	sim := NewSimulation(1, 0, pair()...)
+	sim.Integrator = "unknown"
+
+	var cancelled *CancelledError
+	if err := sim.Run(context.Background(), 1, nil); err == nil || errors.As(err, &cancelled) {
+		t.Fatalf("expected a validation error, got %v", err)
+	}
+	if sim.Step != 0 {
+		t.Fatalf("expected no steps to be taken, got %d", sim.Step)
+	}
+}
+
+func TestClone(t *testing.T) {
+	sim := NewSimulation(1, 0.5, pair()...)
+	sim.Steps(2)
+
+	clone := sim.Clone()
+	clone.Steps(3)
+
+	if sim.Step != 2 || len(sim.DtHistory) != 2 || len(sim.Diagnostics) != 3 {
+		t.Fatalf("expected the original to be left at step 2, got %d", sim.Step)
+	}
+	if sim.Bodies[0] == clone.Bodies[0] {
+		t.Fatal("expected the clone's bodies to have moved on their own")
+	}
+
+	// The clone carries on exactly as the original would
+	sim.Steps(3)
+	for i := range sim.Bodies {
+		if sim.Bodies[i] != clone.Bodies[i] {
+			t.Fatalf("expected body %d to match the clone, %v != %v", i, sim.Bodies[i], clone.Bodies[i])
+		}
+	}
+}
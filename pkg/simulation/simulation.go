@@ -1,60 +1,379 @@
 package simulation
 
 import (
+	"context"
 	"fmt"
 )
 
+const (
+	// DefaultDt is the timestep used by a new Simulation
+	// when one has not been chosen.
+	DefaultDt = 0.01
+	// DefaultRebuildThreshold is the fraction of bodies
+	// which can leave their leaf before a reused oct tree is
+	// rebuilt when one has not been chosen.
+	DefaultRebuildThreshold = 0.1
+	// MaxBlockLevels is the most block timestep levels a
+	// simulation can have, each doubling the substeps taken
+	// every step.
+	MaxBlockLevels = 30
+)
+
 // Simulation holds all of the functionality
 // to start a barnes hut simulation.
 type Simulation struct {
 	// grav is the gravitational constant used to calculate
 	// the forces between bodies.
 	Grav float64 `json:"grav"`
-	// theta is used to determine what granularity
-	// to which to calculate the forces for each Body.
-	// Between 1 and 0, 1 being full granularity.
+	// Units is the name of the units the simulation's values
+	// are in, such as UnitsAstro, when they have been chosen
+	// with SetUnits. Grav must then be the gravitational
+	// constant in those units.
+	Units string `json:"units,omitempty"`
+	// Theta is the opening angle of the Barnes-Hut
+	// approximation. Cells of the oct tree are only treated
+	// as a single mass when they appear smaller than theta
+	// from a body, so 0 calculates every force directly and
+	// larger values are faster but less accurate. Around 0.5
+	// is typical.
 	Theta float64 `json:"theta"`
+	// Opening is the name of the criterion which decides
+	// when a cell can be treated as a single mass, and Alpha
+	// the accuracy parameter of the relative criterion.
+	Opening string  `json:"opening"`
+	Alpha   float64 `json:"alpha,omitempty"`
+	// Order is the highest multipole moment of the cells
+	// used when they are treated as a single mass, see
+	// OrderMonopole. Higher orders are more accurate for the
+	// same theta.
+	Order int `json:"order,omitempty"`
+	// Softening is the length over which the force between
+	// bodies is smoothed by Kernel, the name of the softening
+	// kernel, so close encounters do not produce huge forces.
+	// An empty Kernel is DefaultKernel.
+	Softening float64 `json:"softening"`
+	Kernel    string  `json:"kernel"`
+	// Workers is the number of goroutines the oct tree is
+	// built with and the forces are calculated with, all of
+	// the processors when it is 0.
+	Workers int `json:"workers,omitempty"`
+	// LeafCapacity is the most bodies a leaf of the oct tree
+	// holds, 1 when it is 0. Larger leaves make shallower
+	// trees at the cost of more direct interactions.
+	LeafCapacity int `json:"leafCapacity,omitempty"`
+	// TreeReuse keeps the oct tree between force calculations,
+	// refitting it to the bodies as they move rather than
+	// building a new one. The tree is rebuilt once more than
+	// RebuildThreshold of the bodies have left their leaf,
+	// DefaultRebuildThreshold when it is 0, or once its
+	// leaves have stretched over so much of the others that
+	// the tree has lost DefaultDepthThreshold of its depth.
+	TreeReuse        bool    `json:"treeReuse,omitempty"`
+	RebuildThreshold float64 `json:"rebuildThreshold,omitempty"`
+	// Dt is the amount of simulated time that passes
+	// in a single step.
+	Dt float64 `json:"dt"`
+	// Timestep when set recalculates Dt every step from
+	// the motion of the bodies.
+	Timestep *Timestep `json:"timestep,omitempty"`
+	// BlockLevels when positive gives each body its own
+	// timestep of Timestep.MaxDt / 2^k for k up to
+	// BlockLevels, at most MaxBlockLevels, see blockStep.
+	BlockLevels int `json:"blockLevels,omitempty"`
+	// Integrator is the name of the Integrator used to
+	// advance the bodies each step.
+	Integrator string `json:"integrator"`
+	// Collisions is the name of the response to bodies
+	// overlapping, by default they pass through each other.
+	Collisions string `json:"collisions,omitempty"`
+	// Restitution is the fraction of their approach speed
+	// that bouncing bodies separate at.
+	Restitution float64 `json:"restitution,omitempty"`
+	// Fragments is the number of fragments bodies break
+	// into when they collide fast enough.
+	Fragments int `json:"fragments,omitempty"`
+	// Seed seeds the random numbers used by the simulation.
+	Seed int64 `json:"seed"`
 	// Bodies stores the list of bodies within the simulation
 	Bodies []Body `json:"bodies"`
 	// Step the current number of steps that has taken place.
 	Step int `json:"step"`
+	// Time is the amount of simulated time that has passed.
+	Time float64 `json:"time"`
+	// DtHistory records the timestep taken by each step.
+	DtHistory []float64 `json:"dtHistory,omitempty"`
+	// Events records the collisions that have happened.
+	Events []Event `json:"events,omitempty"`
+	// Diagnostics records the conserved quantities of the
+	// simulation over time, at the start of the run and then
+	// every DiagnosticsEvery steps, every step when it is 0.
+	Diagnostics      []Diagnostics `json:"diagnostics,omitempty"`
+	DiagnosticsEvery int           `json:"diagnosticsEvery,omitempty"`
+
+	// acc holds the acceleration of each body at the end
+	// of the last step, when the integrator found them.
+	acc []Vector
+	// rng generates the random numbers of the simulation.
+	rng *rng
+	// Logger when set records what the simulation is doing,
+	// by default nothing is recorded.
+	Logger Logger `json:"-"`
+
+	// tree is the last oct tree built, kept so it can be
+	// refitted rather than built again.
+	tree *Octree
+	// observers are called as the simulation runs, see
+	// AddObserver.
+	observers []Observer
 }
 
 // NewSimulation returns an instance of a Simulation
 // struct. It initilises some simulation paramaters
 // and can optionally set the bodies for the simulation.
 func NewSimulation(grav, theta float64, bodies ...Body) *Simulation {
+	// Copy the bodies so the caller's slice is not
+	// modified as the simulation runs
+	b := append([]Body(nil), bodies...)
+
 	return &Simulation{
-		Grav:   grav,
-		Theta:  theta,
-		Bodies: bodies,
+		Grav:       grav,
+		Theta:      theta,
+		Opening:    DefaultMAC,
+		Kernel:     DefaultKernel,
+		Dt:         DefaultDt,
+		Integrator: DefaultIntegrator,
+		Bodies:     b,
 	}
 }
 
-// oneStep simulates on tick in the a simulation
-func (s *Simulation) oneStep(bodies []Body) []Body {
+// setDefaults gives the parameters which have not been set,
+// such as those of a Simulation literal, the defaults used by
+// NewSimulation.
+func (s *Simulation) setDefaults() {
+	if s.Opening == "" {
+		s.Opening = DefaultMAC
+	}
+	if s.Kernel == "" {
+		s.Kernel = DefaultKernel
+	}
+	if s.Dt <= 0 {
+		s.Dt = DefaultDt
+	}
+	if s.Integrator == "" {
+		s.Integrator = DefaultIntegrator
+	}
+}
+
+// Validate returns an error if the simulation's
+// parameters cannot be simulated.
+func (s *Simulation) Validate() error {
+	if s.Dt <= 0 {
+		return fmt.Errorf("the timestep must be strictly positive")
+	}
+	if err := s.validateUnits(); err != nil {
+		return err
+	}
+	if _, err := NewIntegrator(s.Integrator); err != nil {
+		return err
+	}
+	if s.Theta < 0 {
+		return fmt.Errorf("theta must not be negative")
+	}
+	if _, err := NewMAC(s.Opening, s.Theta, s.Alpha, s.Grav); err != nil {
+		return err
+	}
+	if err := validateOrder(s.Order); err != nil {
+		return err
+	}
+	if s.Softening < 0 {
+		return fmt.Errorf("the softening length must not be negative")
+	}
+	if err := validateKernel(s.Kernel); err != nil {
+		return err
+	}
+	if s.Workers < 0 {
+		return fmt.Errorf("the number of workers must not be negative")
+	}
+	if s.LeafCapacity < 0 {
+		return fmt.Errorf("the leaf capacity must not be negative")
+	}
+	if s.RebuildThreshold < 0 || s.RebuildThreshold > 1 {
+		return fmt.Errorf("the rebuild threshold must be between 0 and 1")
+	}
+	if s.Timestep != nil {
+		if err := s.Timestep.validate(s.timestepLength()); err != nil {
+			return err
+		}
+	}
+	if err := validateCollisions(s.Collisions); err != nil {
+		return err
+	}
+	if s.Restitution < 0 || s.Restitution > 1 {
+		return fmt.Errorf("the restitution must be between 0 and 1")
+	}
+	if s.BlockLevels < 0 || s.BlockLevels > MaxBlockLevels {
+		return fmt.Errorf("the number of block timestep levels must be between 0 and %d", MaxBlockLevels)
+	}
+	if s.BlockLevels > 0 && s.Timestep == nil {
+		return fmt.Errorf("block timesteps require a timestep criterion")
+	}
+	return nil
+}
+
+// buildTree creates an oct tree containing the bodies
+// with the mass of each node calculated.
+func (s *Simulation) buildTree(bodies []Body) *Octree {
 	// Create a new Oct Tree based on the bodies
-	root := NewRootNode(bodies)
-	root.BuildOcttree(bodies)
-	root.CalcMass()
+	tree := NewOctree(bodies, s.treeOptions())
 
-	// Calculate and apply the forces to all
-	// the bodes in the simulation for a single
-	// tick.
-	root.CalcForces(s.Grav, s.Theta)
+	// Describing the tree takes a pass over it, so only
+	// do so when it will be recorded
+	if s.Logger != nil {
+		stats := tree.Stats()
+		s.logf("step %d: built an oct tree of %d nodes, %d deep", s.Step, stats.Nodes, stats.MaxDepth)
+	}
 
-	// Display the resulting oct tree
-	fmt.Println(root.String(0))
+	return tree
+}
 
-	return root.GetBodies()
+// reuseTree returns an oct tree containing the bodies. The
+// last tree built is refitted to the bodies instead when no more
+// than threshold of them have left their leaf, see refit, and
+// its leaves have not grown so much that it is unbalanced.
+func (s *Simulation) reuseTree(bodies []Body, threshold float64) *Octree {
+	if s.tree == nil || s.tree.refit(bodies) > threshold || s.tree.unbalanced() {
+		s.tree = s.buildTree(bodies)
+	}
+	return s.tree
 }
 
-// Steps simulates a number of steps in a simulation
+// rebuildThreshold returns the fraction of bodies which can
+// leave their leaf before a reused tree is rebuilt.
+func (s *Simulation) rebuildThreshold() float64 {
+	if s.RebuildThreshold > 0 {
+		return s.RebuildThreshold
+	}
+	return DefaultRebuildThreshold
+}
+
+// treeOptions returns the parameters used to build the
+// oct tree.
+func (s *Simulation) treeOptions() TreeOptions {
+	return TreeOptions{
+		Workers:      s.Workers,
+		LeafCapacity: s.LeafCapacity,
+	}
+}
+
+// forceOptions returns the parameters used to calculate
+// the forces between the bodies.
+func (s *Simulation) forceOptions() ForceOptions {
+	mac, _ := NewMAC(s.Opening, s.Theta, s.Alpha, s.Grav)
+	return ForceOptions{
+		Grav:      s.Grav,
+		MAC:       mac,
+		Order:     s.Order,
+		Previous:  s.acc,
+		Softening: s.Softening,
+		Kernel:    s.Kernel,
+		Workers:   s.Workers,
+	}
+}
+
+// timestepLength returns the length scale of the timestep
+// criteria, the softening length unless one is given.
+func (s *Simulation) timestepLength() float64 {
+	if s.Timestep.Length > 0 {
+		return s.Timestep.Length
+	}
+	return s.Softening
+}
+
+// accelerations builds an oct tree from the bodies, or refits
+// the last one when TreeReuse is set, and uses it to find the
+// acceleration of each body.
+func (s *Simulation) accelerations(bodies []Body) []Vector {
+	var tree *Octree
+	if s.TreeReuse {
+		tree = s.reuseTree(bodies, s.rebuildThreshold())
+	} else {
+		tree = s.buildTree(bodies)
+	}
+
+	// Calculate the forces on all of the bodies
+	return tree.Accelerations(s.forceOptions())
+}
+
+// oneStep simulates on tick in the a simulation
+func (s *Simulation) oneStep(integrator Integrator) {
+	// The accelerations are only kept between steps
+	// when the integrator calculated them
+	if len(s.acc) != len(s.Bodies) {
+		s.acc = s.accelerations(s.Bodies)
+	}
+
+	// Choose the timestep from the accelerations at
+	// the start of the step
+	if s.Timestep != nil {
+		s.Dt = s.Timestep.dt(s.Bodies, s.acc, s.timestepLength())
+	}
+
+	s.acc = integrator.Integrate(s.Bodies, s.acc, s.Dt, s.accelerations)
+
+	s.Time += s.Dt
+	s.DtHistory = append(s.DtHistory, s.Dt)
+}
+
+// Steps simulates a number of steps in a simulation. The
+// parameters left unset are given the defaults NewSimulation
+// would give them first, see setDefaults. It panics if the
+// simulation is still not valid, see Validate, and cannot be
+// stopped part way through, see Run.
 func (s *Simulation) Steps(steps int) []Body {
-	for i := 0; i < steps; i++ {
-		s.Step++
-		fmt.Printf("---------- Step %v ----------\n", s.Step)
-		s.Bodies = s.oneStep(s.Bodies)
+	s.setDefaults()
+	if err := s.Run(context.Background(), steps, nil); err != nil {
+		panic(err)
 	}
 	return s.Bodies
 }
+
+// step simulates a single step, including its collisions and
+// diagnostics.
+func (s *Simulation) step(integrator Integrator) {
+	s.beforeStep()
+	s.Step++
+	if s.BlockLevels > 0 {
+		s.blockStep()
+	} else {
+		s.oneStep(integrator)
+	}
+	s.collide()
+	s.diagnose()
+	s.logf("step %d: time %g, dt %g, %d bodies", s.Step, s.Time, s.Dt, len(s.Bodies))
+	s.afterStep()
+}
+
+// Clone returns a copy of the simulation which can be run
+// without changing the original. The copy shares the original's
+// Logger and observers.
+func (s *Simulation) Clone() *Simulation {
+	c := *s
+	c.Bodies = append([]Body(nil), s.Bodies...)
+	c.DtHistory = append([]float64(nil), s.DtHistory...)
+	c.Events = append([]Event(nil), s.Events...)
+	c.Diagnostics = append([]Diagnostics(nil), s.Diagnostics...)
+	c.observers = append([]Observer(nil), s.observers...)
+	c.acc = append([]Vector(nil), s.acc...)
+	if s.Timestep != nil {
+		timestep := *s.Timestep
+		c.Timestep = &timestep
+	}
+	if s.rng != nil {
+		r := *s.rng
+		c.rng = &r
+	}
+
+	// The tree refers to the original's bodies
+	c.tree = nil
+	return &c
+}
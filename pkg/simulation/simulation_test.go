@@ -0,0 +1,170 @@
+package simulation
+
+import (
+	"math"
+	"testing"
+)
+
+// unitMassDensity is the density of a body with a radius of
+// 0.01 that has a mass of 1.
+const unitMassDensity = 3 / (4 * math.Pi * 1e-6)
+
+// pair returns two bodies of unit mass a distance of 1
+// apart on a circular orbit around each other when G = 1.
+func pair() []Body {
+	v := math.Sqrt(0.5)
+	return []Body{
+		{Name: "a", X: -0.5, VY: -v, Radius: 0.01, Density: unitMassDensity},
+		{Name: "b", X: 0.5, VY: v, Radius: 0.01, Density: unitMassDensity},
+	}
+}
+
+func TestIntegratorsKeepCircularOrbit(t *testing.T) {
+	period := 2 * math.Pi / math.Sqrt(2)
+
+	for _, name := range []string{IntegratorEuler, IntegratorLeapfrog, IntegratorVerlet, IntegratorRK4} {
+		sim := NewSimulation(1, 0, pair()...)
+		sim.Integrator = name
+		sim.Dt = period / 1000
+		sim.Steps(1000)
+
+		dx := sim.Bodies[1].X - sim.Bodies[0].X
+		dy := sim.Bodies[1].Y - sim.Bodies[0].Y
+		dz := sim.Bodies[1].Z - sim.Bodies[0].Z
+		separation := math.Sqrt(dx*dx + dy*dy + dz*dz)
+
+		if math.Abs(separation-1) > 1e-2 {
+			t.Fatalf("%s: expected separation 1 after an orbit, got %f", name, separation)
+		}
+	}
+}
+
+func TestStepsDefaults(t *testing.T) {
+	// A literal with only bodies steps with the defaults
+	sim := &Simulation{Grav: 1, Bodies: pair()}
+	sim.Steps(10)
+
+	if sim.Dt != DefaultDt || sim.Integrator != DefaultIntegrator || sim.Kernel != DefaultKernel || sim.Opening != DefaultMAC {
+		t.Fatalf("expected the default parameters, got %+v", sim)
+	}
+	if math.Abs(sim.Time-10*DefaultDt) > 1e-12 {
+		t.Fatalf("expected a time of %v, got %v", 10*DefaultDt, sim.Time)
+	}
+}
+
+func TestUnknownIntegrator(t *testing.T) {
+	sim := NewSimulation(1, 0, pair()...)
+	sim.Integrator = "unknown"
+
+	if err := sim.Validate(); err == nil {
+		t.Fatal("expected an error for an unknown integrator")
+	}
+}
+
+func TestAdaptiveTimestep(t *testing.T) {
+	bodies := pair()
+	// Slow the bodies so the orbit is eccentric
+	bodies[0].VY /= 2
+	bodies[1].VY /= 2
+
+	sim := NewSimulation(1, 0, bodies...)
+	sim.Timestep = &Timestep{Eta: 0.01, Length: 1, MinDt: 1e-5, MaxDt: 0.1}
+	sim.Steps(500)
+
+	if len(sim.DtHistory) != 500 {
+		t.Fatalf("expected 500 recorded timesteps, got %d", len(sim.DtHistory))
+	}
+
+	min, max, total := math.Inf(1), 0.0, 0.0
+	for _, dt := range sim.DtHistory {
+		min = math.Min(min, dt)
+		max = math.Max(max, dt)
+		total += dt
+	}
+
+	if min < sim.Timestep.MinDt || max > sim.Timestep.MaxDt {
+		t.Fatalf("timesteps outside of bounds: min %f max %f", min, max)
+	}
+	if min == max {
+		t.Fatal("expected the timestep to change along an eccentric orbit")
+	}
+	if math.Abs(total-sim.Time) > 1e-9 {
+		t.Fatalf("expected simulated time %f, got %f", total, sim.Time)
+	}
+}
+
+func TestBlockTimesteps(t *testing.T) {
+	// A tight binary with a distant companion, which
+	// should sit in a longer timestep bin
+	bodies := append(pair(), Body{
+		Name: "c", X: 20, VY: math.Sqrt(2.0 / 20), Radius: 0.01, Density: unitMassDensity,
+	})
+
+	reference := NewSimulation(1, 0, bodies...)
+	reference.Dt = 1e-3
+	reference.Steps(2000)
+
+	sim := NewSimulation(1, 0, bodies...)
+	sim.Timestep = &Timestep{Eta: 0.01, Length: 1, MinDt: 1e-4, MaxDt: 0.08}
+	sim.BlockLevels = 6
+	sim.Steps(25)
+
+	if math.Abs(sim.Time-reference.Time) > 1e-9 {
+		t.Fatalf("expected simulated time %f, got %f", reference.Time, sim.Time)
+	}
+
+	for i := range sim.Bodies {
+		dx := sim.Bodies[i].X - reference.Bodies[i].X
+		dy := sim.Bodies[i].Y - reference.Bodies[i].Y
+		dz := sim.Bodies[i].Z - reference.Bodies[i].Z
+		if d := math.Sqrt(dx*dx + dy*dy + dz*dz); d > 1e-2 {
+			t.Fatalf("body %s is %f away from the reference", sim.Bodies[i].Name, d)
+		}
+	}
+}
+
+func TestBlockLevelsLimit(t *testing.T) {
+	sim := NewSimulation(1, 0, pair()...)
+	sim.Timestep = &Timestep{Eta: 0.01, Length: 1, MinDt: 1e-4, MaxDt: 0.08}
+
+	for _, levels := range []int{-1, MaxBlockLevels + 1, 64} {
+		sim.BlockLevels = levels
+		if err := sim.Validate(); err == nil {
+			t.Fatalf("expected an error for %d block levels", levels)
+		}
+	}
+
+	sim.BlockLevels = MaxBlockLevels
+	if err := sim.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTreeReuse(t *testing.T) {
+	bodies := cloud(100, 17)
+
+	rebuilt := NewSimulation(1, 0.5, bodies...)
+	rebuilt.Softening = 0.05
+	rebuilt.Dt = 1e-3
+	rebuilt.Steps(20)
+
+	reused := NewSimulation(1, 0.5, bodies...)
+	reused.Softening = 0.05
+	reused.Dt = 1e-3
+	reused.TreeReuse = true
+	reused.Steps(20)
+
+	for i := range bodies {
+		dx := reused.Bodies[i].X - rebuilt.Bodies[i].X
+		dy := reused.Bodies[i].Y - rebuilt.Bodies[i].Y
+		dz := reused.Bodies[i].Z - rebuilt.Bodies[i].Z
+		if d := math.Sqrt(dx*dx + dy*dy + dz*dz); d > 1e-3 {
+			t.Fatalf("body %d is %g away from where it is with a new tree each step", i, d)
+		}
+	}
+
+	reused.RebuildThreshold = 2
+	if err := reused.Validate(); err == nil {
+		t.Fatal("expected an error for a rebuild threshold above 1")
+	}
+}
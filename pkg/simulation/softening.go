@@ -0,0 +1,98 @@
+package simulation
+
+import (
+	"fmt"
+	"math"
+)
+
+const (
+	// KernelNone applies no softening, the force is the
+	// Newtonian 1/r^2 at every distance.
+	KernelNone = "none"
+	// KernelPlummer softens the force as if every body
+	// were a Plummer sphere with a scale length of the
+	// softening length.
+	KernelPlummer = "plummer"
+	// KernelSpline softens the force with the cubic spline
+	// kernel of Monaghan & Lattanzio. The force is exactly
+	// Newtonian beyond 2.8 softening lengths.
+	KernelSpline = "spline"
+
+	// DefaultKernel is the softening kernel used by a new
+	// Simulation.
+	DefaultKernel = KernelPlummer
+
+	// splineScale is the radius of the spline kernel in
+	// softening lengths, chosen so the potential at r = 0
+	// matches a Plummer sphere.
+	splineScale = 2.8
+)
+
+// validateKernel returns an error if the softening
+// kernel is not known. An empty name is DefaultKernel.
+func validateKernel(kernel string) error {
+	switch kernel {
+	case "", KernelNone, KernelPlummer, KernelSpline:
+		return nil
+	}
+	return fmt.Errorf("unknown softening kernel %q", kernel)
+}
+
+// softenedForce returns the factor g such that the
+// acceleration towards a point of mass m at distance r
+// is G * m * r * g. Without softening g is 1/r^3. Bodies
+// at the same position exert no force on each other. An
+// empty kernel is DefaultKernel.
+func softenedForce(kernel string, r, eps float64) float64 {
+	if kernel == "" {
+		kernel = DefaultKernel
+	}
+	if eps > 0 {
+		switch kernel {
+		case KernelPlummer:
+			return math.Pow(r*r+eps*eps, -1.5)
+		case KernelSpline:
+			h := splineScale * eps
+			u := r / h
+			if u < 0.5 {
+				return (10.666666666667 + u*u*(32.0*u-38.4)) / (h * h * h)
+			} else if u < 1 {
+				return (21.333333333333 - 48.0*u + 38.4*u*u - 10.666666666667*u*u*u - 0.066666666667/(u*u*u)) / (h * h * h)
+			}
+		}
+	}
+
+	if r == 0 {
+		return 0
+	}
+	return 1 / (r * r * r)
+}
+
+// softenedPotential returns the factor f such that the
+// potential of a point of mass m at distance r is -G * m * f.
+// Without softening f is 1/r. An empty kernel is
+// DefaultKernel.
+func softenedPotential(kernel string, r, eps float64) float64 {
+	if kernel == "" {
+		kernel = DefaultKernel
+	}
+	if eps > 0 {
+		switch kernel {
+		case KernelPlummer:
+			return 1 / math.Sqrt(r*r+eps*eps)
+		case KernelSpline:
+			h := splineScale * eps
+			u := r / h
+			if u < 0.5 {
+				return -(-2.8 + u*u*(5.333333333333+u*u*(6.4*u-9.6))) / h
+			} else if u < 1 {
+				return -(-3.2 + 0.066666666667/u + u*u*(10.666666666667+u*(-16.0+u*(9.6-2.133333333333*u)))) / h
+			}
+		}
+	}
+
+	if r == 0 {
+		return 0
+	}
+	return 1 / r
+}
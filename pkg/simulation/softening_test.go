@@ -0,0 +1,46 @@
+package simulation
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSofteningKernels(t *testing.T) {
+	eps := 0.1
+
+	for _, kernel := range []string{KernelPlummer, KernelSpline} {
+		// The softened force must stay finite at zero
+		// separation and be continuous
+		previous := softenedForce(kernel, 0, eps)
+		if math.IsInf(previous, 0) || math.IsNaN(previous) {
+			t.Fatalf("%s: force at r = 0 is not finite", kernel)
+		}
+
+		for r := 0.001; r < 1; r += 0.001 {
+			g := softenedForce(kernel, r, eps) * r * r * r
+			if g > 1+1e-9 {
+				t.Fatalf("%s: softened force is stronger than Newtonian at r = %f", kernel, r)
+			}
+		}
+	}
+
+	// The spline kernel is exactly Newtonian outside of
+	// its radius
+	r := splineScale*eps + 0.01
+	if got, want := softenedForce(KernelSpline, r, eps), 1/(r*r*r); math.Abs(got-want) > 1e-12*want {
+		t.Fatalf("spline: expected %g beyond the kernel, got %g", want, got)
+	}
+
+	// Without softening the force is Newtonian
+	if got, want := softenedForce(KernelNone, 0.5, eps), 8.0; got != want {
+		t.Fatalf("none: expected %g, got %g", want, got)
+	}
+
+	// No kernel is the default one rather than no softening
+	if got, want := softenedForce("", 0.5, eps), softenedForce(DefaultKernel, 0.5, eps); got != want {
+		t.Fatalf("default: expected %g, got %g", want, got)
+	}
+	if got, want := softenedPotential("", 0.5, eps), softenedPotential(DefaultKernel, 0.5, eps); got != want {
+		t.Fatalf("default: expected a potential of %g, got %g", want, got)
+	}
+}
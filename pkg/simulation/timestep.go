@@ -0,0 +1,84 @@
+package simulation
+
+import (
+	"fmt"
+	"math"
+)
+
+// Timestep chooses the length of each step from the
+// accelerations and velocities of the bodies, so close
+// encounters are resolved with short steps while quiet
+// phases take long ones.
+//
+// Each body's timestep is the smaller of
+//
+//	Eta * sqrt(Length / |a|)
+//	EtaV * Length / |v|
+//
+// and the step taken is the smallest of these clamped
+// between MinDt and MaxDt.
+type Timestep struct {
+	// Eta scales the acceleration criterion, smaller
+	// values give shorter, more accurate steps.
+	Eta float64 `json:"eta"`
+	// EtaV scales the velocity criterion which limits how
+	// far a body may move in a step, 0 disables it.
+	EtaV float64 `json:"etaV,omitempty"`
+	// Length is the length scale of the criteria, the
+	// simulation's softening length is used when it is 0.
+	Length float64 `json:"length,omitempty"`
+	// MinDt and MaxDt bound the timestep chosen.
+	MinDt float64 `json:"minDt"`
+	MaxDt float64 `json:"maxDt"`
+}
+
+// validate returns an error if the timestep criteria
+// cannot be used with the length scale given.
+func (t *Timestep) validate(length float64) error {
+	if t.Eta <= 0 {
+		return fmt.Errorf("the timestep eta must be strictly positive")
+	}
+	if t.EtaV < 0 {
+		return fmt.Errorf("the timestep etaV must not be negative")
+	}
+	if length <= 0 {
+		return fmt.Errorf("the timestep length must be strictly positive")
+	}
+	if t.MinDt <= 0 || t.MaxDt < t.MinDt {
+		return fmt.Errorf("the timestep bounds must satisfy 0 < minDt <= maxDt")
+	}
+	return nil
+}
+
+// bodyDt returns the timestep the criteria choose for
+// a body with the acceleration a, before clamping.
+func (t *Timestep) bodyDt(b Body, a Vector, length float64) float64 {
+	dt := math.Inf(1)
+
+	if acc := a.Length(); acc > 0 {
+		dt = math.Min(dt, t.Eta*math.Sqrt(length/acc))
+	}
+
+	if t.EtaV > 0 {
+		if vel := math.Sqrt(b.VX*b.VX + b.VY*b.VY + b.VZ*b.VZ); vel > 0 {
+			dt = math.Min(dt, t.EtaV*length/vel)
+		}
+	}
+
+	return dt
+}
+
+// clamp limits dt to be between MinDt and MaxDt.
+func (t *Timestep) clamp(dt float64) float64 {
+	return math.Max(t.MinDt, math.Min(t.MaxDt, dt))
+}
+
+// dt returns the timestep for the next step of the bodies
+// given their current accelerations.
+func (t *Timestep) dt(bodies []Body, acc []Vector, length float64) float64 {
+	dt := math.Inf(1)
+	for i := range bodies {
+		dt = math.Min(dt, t.bodyDt(bodies[i], acc[i], length))
+	}
+	return t.clamp(dt)
+}
@@ -0,0 +1,200 @@
+package simulation
+
+import (
+	"fmt"
+	"math"
+)
+
+const (
+	// UnitsSI measures in metres, kilograms and seconds.
+	UnitsSI = "si"
+	// UnitsAstro measures in astronomical units, solar masses
+	// and Julian years, suiting planetary systems.
+	UnitsAstro = "astro"
+	// UnitsGalactic measures in kiloparsecs, 10^10 solar
+	// masses and gigayears, suiting galaxies.
+	UnitsGalactic = "galactic"
+	// UnitsNBody are N-body units, in which G is 1. They have
+	// no fixed size, so cannot be converted to the others.
+	UnitsNBody = "nbody"
+
+	// GravitationalConstant is G in SI units, the 2018 CODATA
+	// value.
+	GravitationalConstant = 6.67430e-11
+	// AstronomicalUnit is the length of an astronomical unit
+	// in metres.
+	AstronomicalUnit = 1.495978707e11
+	// SolarMass is the mass of the Sun in kilograms, found
+	// from the IAU's nominal solar mass parameter GM, which is
+	// known far better than G.
+	SolarMass = 1.3271244e20 / GravitationalConstant
+	// JulianYear is the length of a Julian year in seconds.
+	JulianYear = 365.25 * 24 * 60 * 60
+	// Parsec is the length of a parsec in metres.
+	Parsec = 648000 / math.Pi * AstronomicalUnit
+
+	// unitsTolerance is the largest relative difference
+	// between the gravitational constant of a simulation and
+	// that of its units.
+	unitsTolerance = 1e-9
+)
+
+// Units is a system of units for the lengths, masses and times
+// of a simulation. The gravitational constant follows from
+// them.
+type Units struct {
+	// Name is the name the units are chosen by, such as
+	// UnitsSI.
+	Name string `json:"name"`
+	// Length, Mass and Time are the size of each unit in
+	// metres, kilograms and seconds, all 0 for N-body units.
+	Length float64 `json:"length,omitempty"`
+	Mass   float64 `json:"mass,omitempty"`
+	Time   float64 `json:"time,omitempty"`
+}
+
+// NewUnits returns the Units with the name given.
+func NewUnits(name string) (Units, error) {
+	switch name {
+	case UnitsSI:
+		return Units{Name: name, Length: 1, Mass: 1, Time: 1}, nil
+	case UnitsAstro:
+		return Units{Name: name, Length: AstronomicalUnit, Mass: SolarMass, Time: JulianYear}, nil
+	case UnitsGalactic:
+		return Units{Name: name, Length: 1e3 * Parsec, Mass: 1e10 * SolarMass, Time: 1e9 * JulianYear}, nil
+	case UnitsNBody:
+		return Units{Name: name}, nil
+	}
+	return Units{}, fmt.Errorf("unknown units %q", name)
+}
+
+// G returns the gravitational constant in the units.
+func (u Units) G() float64 {
+	if u.Name == UnitsNBody {
+		return 1
+	}
+	return GravitationalConstant * u.Mass * u.Time * u.Time / (u.Length * u.Length * u.Length)
+}
+
+// SetUnits chooses the units of the simulation, setting Grav
+// to the gravitational constant in them. The simulation's
+// values are not changed, see Convert for that.
+func (s *Simulation) SetUnits(name string) error {
+	u, err := NewUnits(name)
+	if err != nil {
+		return err
+	}
+
+	s.Units = name
+	s.Grav = u.G()
+	return nil
+}
+
+// validateUnits returns an error if the simulation's units are
+// not known or its gravitational constant does not match them.
+func (s *Simulation) validateUnits() error {
+	if s.Units == "" {
+		return nil
+	}
+
+	u, err := NewUnits(s.Units)
+	if err != nil {
+		return err
+	}
+	if g := u.G(); math.Abs(s.Grav-g) > unitsTolerance*g {
+		return fmt.Errorf("the gravitational constant %v does not match the %s units, in which it is %v", s.Grav, s.Units, g)
+	}
+	return nil
+}
+
+// Scale returns the factors the simulation's lengths, masses
+// and times are multiplied by to give them in the units with
+// the name given. Both the simulation's units and those it is
+// converted to must have a fixed size, unless they are the
+// same.
+func (s *Simulation) Scale(name string) (length, mass, time float64, err error) {
+	if s.Units == "" {
+		return 0, 0, 0, fmt.Errorf("the simulation has no units to convert from")
+	}
+
+	from, err := NewUnits(s.Units)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	to, err := NewUnits(name)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	if from.Name == to.Name {
+		return 1, 1, 1, nil
+	}
+	if from.Name == UnitsNBody || to.Name == UnitsNBody {
+		other := from.Name
+		if other == UnitsNBody {
+			other = to.Name
+		}
+		return 0, 0, 0, fmt.Errorf("N-body units cannot be converted to or from %s units", other)
+	}
+	return from.Length / to.Length, from.Mass / to.Mass, from.Time / to.Time, nil
+}
+
+// Convert returns a copy of the simulation with its lengths,
+// masses, times and everything derived from them, including
+// Grav and the recorded diagnostics, converted to the units
+// with the name given, see Scale.
+func (s *Simulation) Convert(name string) (*Simulation, error) {
+	l, m, t, err := s.Scale(name)
+	if err != nil {
+		return nil, err
+	}
+
+	c := s.Clone()
+	if name == s.Units {
+		return c, nil
+	}
+
+	// The factors velocities and energies are multiplied by
+	velocity := l / t
+	energy := m * velocity * velocity
+
+	to, _ := NewUnits(name)
+	c.Units = to.Name
+	c.Grav = to.G()
+	c.Softening *= l
+	c.Dt *= t
+	c.Time *= t
+	if c.Timestep != nil {
+		c.Timestep.Length *= l
+		c.Timestep.MinDt *= t
+		c.Timestep.MaxDt *= t
+	}
+
+	for i := range c.Bodies {
+		b := &c.Bodies[i]
+		b.X, b.Y, b.Z = b.X*l, b.Y*l, b.Z*l
+		b.VX, b.VY, b.VZ = b.VX*velocity, b.VY*velocity, b.VZ*velocity
+		b.Radius *= l
+		b.Density *= m / (l * l * l)
+	}
+	for i := range c.acc {
+		c.acc[i] = c.acc[i].Scale(velocity / t)
+	}
+	for i := range c.DtHistory {
+		c.DtHistory[i] *= t
+	}
+	for i := range c.Events {
+		c.Events[i].Time *= t
+	}
+	for i := range c.Diagnostics {
+		d := &c.Diagnostics[i]
+		d.Time *= t
+		d.Kinetic *= energy
+		d.Potential *= energy
+		d.Total *= energy
+		d.Momentum = d.Momentum.Scale(m * velocity)
+		d.AngularMomentum = d.AngularMomentum.Scale(m * l * velocity)
+		d.CenterOfMass = d.CenterOfMass.Scale(l)
+	}
+	return c, nil
+}
@@ -0,0 +1,94 @@
+package simulation
+
+import (
+	"math"
+	"testing"
+)
+
+func TestUnitsG(t *testing.T) {
+	tests := map[string]float64{
+		UnitsSI:       6.6743e-11,
+		UnitsAstro:    4 * math.Pi * math.Pi,
+		UnitsGalactic: 44985,
+		UnitsNBody:    1,
+	}
+
+	for name, g := range tests {
+		u, err := NewUnits(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if math.Abs(u.G()-g) > 1e-4*g {
+			t.Fatalf("%s: expected G close to %v, got %v", name, g, u.G())
+		}
+	}
+
+	if _, err := NewUnits("imperial"); err == nil {
+		t.Fatal("expected an error for unknown units")
+	}
+}
+
+func TestUnitsValidate(t *testing.T) {
+	sim := NewSimulation(9.81, 0.5, pair()...)
+	sim.Units = UnitsSI
+	if err := sim.Validate(); err == nil {
+		t.Fatal("expected an error for a gravitational constant not matching the units")
+	}
+
+	if err := sim.SetUnits(UnitsSI); err != nil {
+		t.Fatal(err)
+	}
+	if err := sim.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUnitsConvert(t *testing.T) {
+	// The Earth orbiting the Sun
+	sim := NewSimulation(0, 0,
+		Body{Name: "Sun", Radius: 0.00465, Density: 1 / (4.0 / 3.0 * math.Pi * math.Pow(0.00465, 3))},
+		Body{Name: "Earth", X: 1, VY: 2 * math.Pi, Radius: 4.26e-5, Density: 3e-6 / (4.0 / 3.0 * math.Pi * math.Pow(4.26e-5, 3))},
+	)
+	if err := sim.SetUnits(UnitsAstro); err != nil {
+		t.Fatal(err)
+	}
+	sim.Dt = 0.001
+	sim.Steps(10)
+
+	si, err := sim.Convert(UnitsSI)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	earth := si.Bodies[1]
+	if math.Abs(earth.X-sim.Bodies[1].X*AstronomicalUnit) > 1e-6*AstronomicalUnit {
+		t.Fatalf("unexpected position %v", earth.X)
+	}
+	if speed := math.Hypot(earth.VX, earth.VY); math.Abs(speed-29.8e3) > 0.1e3 {
+		t.Fatalf("expected the Earth to orbit at around 29.8 km/s, got %v", speed)
+	}
+	if mass := si.Bodies[0].mass(); math.Abs(mass-SolarMass) > 1e-9*SolarMass {
+		t.Fatalf("expected the Sun to have a mass of %v, got %v", SolarMass, mass)
+	}
+	if math.Abs(si.Time-10*0.001*JulianYear) > 1e-6 {
+		t.Fatalf("unexpected time %v", si.Time)
+	}
+	if err := si.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Converting back gives the same simulation, and the
+	// energy is converted along with it
+	back, err := si.Convert(UnitsAstro)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(back.Bodies[1].X-sim.Bodies[1].X) > 1e-12 ||
+		math.Abs(back.Diagnostics[0].Total-sim.Diagnostics[0].Total) > 1e-12*math.Abs(sim.Diagnostics[0].Total) {
+		t.Fatalf("expected the converted simulation back, got %+v", back.Bodies[1])
+	}
+
+	if _, err := sim.Convert(UnitsNBody); err == nil {
+		t.Fatal("expected an error converting to N-body units")
+	}
+}
@@ -0,0 +1,45 @@
+package simulation
+
+import "math"
+
+// Vector is a quantity with an X, Y and Z component,
+// such as a position, velocity or acceleration.
+type Vector struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+	Z float64 `json:"z"`
+}
+
+// Add returns the sum of the vectors v and u.
+func (v Vector) Add(u Vector) Vector {
+	return Vector{v.X + u.X, v.Y + u.Y, v.Z + u.Z}
+}
+
+// Sub returns the vector u subtracted from v.
+func (v Vector) Sub(u Vector) Vector {
+	return Vector{v.X - u.X, v.Y - u.Y, v.Z - u.Z}
+}
+
+// Scale returns the vector v multiplied by s.
+func (v Vector) Scale(s float64) Vector {
+	return Vector{v.X * s, v.Y * s, v.Z * s}
+}
+
+// Dot returns the dot product of the vectors v and u.
+func (v Vector) Dot(u Vector) float64 {
+	return v.X*u.X + v.Y*u.Y + v.Z*u.Z
+}
+
+// Cross returns the cross product of the vectors v and u.
+func (v Vector) Cross(u Vector) Vector {
+	return Vector{
+		v.Y*u.Z - v.Z*u.Y,
+		v.Z*u.X - v.X*u.Z,
+		v.X*u.Y - v.Y*u.X,
+	}
+}
+
+// Length returns the magnitude of the vector.
+func (v Vector) Length() float64 {
+	return math.Sqrt(v.Dot(v))
+}